@@ -0,0 +1,335 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Role - what a client may do, granted through the loan.role certificate attribute
+type Role string
+
+const (
+	RoleBorrower Role = "borrower" // own Agreements only
+	RoleLender   Role = "lender"   // own Agreements only
+	RoleServicer Role = "servicer" // services every loan
+	RoleAuditor  Role = "auditor"  // reads everything, writes nothing
+	RoleAdmin    Role = "admin"    // everything, only from the admin MSPs when any are configured
+)
+
+// RoleAttribute - certificate attribute holding a comma separated list of roles
+const RoleAttribute = "loan.role"
+
+// AdminMSPsKey - state key of the AdminMSPs, set by Init
+const AdminMSPsKey = "_AdminMSPs"
+
+// RoleMSPsKey - state key of the RoleMSPs, see set_role_msps
+const RoleMSPsKey = "_RoleMSPs"
+
+type AdminMSPs struct { // MSP IDs whose admin role is honoured
+	Document
+	MSPs []string `json:"msps"`
+}
+
+type RoleMSPs struct { // MSP IDs whose clients may hold every other role, keyed by role
+	Document
+	Roles map[Role][]string `json:"roles"`
+}
+
+var mspMigrations = map[int]migration{} //role bindings were added at schema version 3
+
+// privilegedRoles - roles honoured from no MSP at all until MSPs are configured for them,
+// borrowers and lenders are honoured from every MSP until then
+var privilegedRoles = map[Role]bool{RoleAdmin: true, RoleServicer: true, RoleAuditor: true}
+
+var everyone = []Role{RoleBorrower, RoleLender, RoleServicer, RoleAuditor, RoleAdmin}
+
+// invokePolicies - roles allowed to call each Invoke function
+var invokePolicies = map[string][]Role{
+	"init":                {RoleAdmin},
+	"set_role_msps":       {RoleAdmin},
+	"create_agreement":    {RoleBorrower, RoleLender, RoleServicer, RoleAdmin},
+	"update_po":           {RoleBorrower, RoleLender, RoleServicer, RoleAdmin},
+	"update_agreement":    {RoleBorrower, RoleLender, RoleServicer, RoleAdmin},
+	"update_status":       {RoleLender, RoleServicer, RoleAdmin},
+	"delete_po":           {RoleServicer, RoleAdmin},
+	"restore_agreement":   {RoleServicer, RoleAdmin},
+	"purge_agreement":     {RoleAdmin},
+	"borrower_sign":       {RoleBorrower},
+	"lender_sign":         {RoleLender},
+	"record_repayment":    {RoleLender, RoleServicer},
+	"accrue_interest":     {RoleLender, RoleServicer, RoleAdmin},
+	"propose_amendment":   {RoleBorrower, RoleLender},
+	"approve_amendment":   {RoleBorrower, RoleLender},
+	"reject_amendment":    {RoleBorrower, RoleLender},
+	"register_party":      {RoleServicer, RoleAdmin},
+	"update_party":        {RoleServicer, RoleAdmin},
+	"register_collateral": {RoleBorrower, RoleLender, RoleServicer, RoleAdmin},
+	"pledge_collateral":   {RoleBorrower, RoleLender, RoleServicer, RoleAdmin},
+	"release_collateral":  {RoleLender, RoleServicer, RoleAdmin},
+	"check_overdue":       {RoleServicer, RoleAdmin},
+	"set_overdue_policy":  {RoleAdmin},
+	"migrate_index":       {RoleAdmin},
+}
+
+// queryPolicies - roles allowed to call each Query function, borrowers and lenders only see their own Agreements
+var queryPolicies = map[string][]Role{
+	"getAgreement_byID":            everyone,
+	"getAgreement_byBuyer":         everyone,
+	"getAgreement_bySeller":        everyone,
+	"get_AllAgreement":             everyone,
+	"getRepayments_byAgreement":    everyone,
+	"getSchedule":                  everyone,
+	"getAccruedInterest":           everyone,
+	"getAmendments_byAgreement":    everyone,
+	"getParty_byID":                everyone,
+	"getCollateral_byID":           everyone,
+	"getLoanToValue":               everyone,
+	"getOverduePolicy":             everyone,
+	"getAgreement_byStatus":        everyone,
+	"getAgreement_byRepaymentDate": everyone,
+	"query_agreements":             everyone,
+	"getAgreement_history":         everyone,
+	"get_schema":                   everyone,
+}
+
+// ============================================================================================================================
+// parseRoles - split the role attribute, unknown roles are dropped
+// ============================================================================================================================
+func parseRoles(s string) []Role {
+	var roles []Role
+	for _, r := range strings.Split(s, ",") {
+		role := Role(strings.ToLower(strings.TrimSpace(r)))
+		for _, known := range everyone {
+			if role == known {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
+
+// HasRole - check the caller holds a role
+func (c Caller) HasRole(role Role) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// SeesAll - servicers, auditors and admins are not limited to their own Agreements
+func (c Caller) SeesAll() bool {
+	return c.HasRole(RoleServicer) || c.HasRole(RoleAuditor) || c.HasRole(RoleAdmin)
+}
+
+// IsPartyTo - the caller acts for the borrower or lender of the Agreement, in the matching role
+func (c Caller) IsPartyTo(res *Agreement) bool {
+	if c.Party == "" {
+		return false
+	}
+	return (c.HasRole(RoleBorrower) && res.BorrowerID == c.Party) || (c.HasRole(RoleLender) && res.LenderID == c.Party)
+}
+
+// ============================================================================================================================
+// authorize - check the caller holds one of the roles the policy of a function allows
+// ============================================================================================================================
+func authorize(stub shim.ChaincodeStubInterface, function string, policies map[string][]Role) error {
+	allowed, ok := policies[function]
+	if !ok {
+		return forbidden("Access denied: no access policy for %s", function)
+	}
+	caller, err := getCallerWithRoles(stub)
+	if err != nil {
+		return err
+	}
+	for _, role := range allowed {
+		if caller.HasRole(role) {
+			return nil
+		}
+	}
+	if function == "init" { //the first init binds the admin role, an admin of any MSP may run it
+		msps, err := roleMSPs(stub)
+		if err != nil {
+			return err
+		}
+		unbound, err := getCaller(stub)
+		if err != nil {
+			return err
+		}
+		if len(msps[RoleAdmin]) == 0 && unbound.HasRole(RoleAdmin) {
+			return nil
+		}
+	}
+	return forbidden("Access denied: %s needs one of the roles %v", function, allowed)
+}
+
+// ============================================================================================================================
+// roleMSPs - the MSP IDs configured for each role, the admin ones from AdminMSPsKey
+// ============================================================================================================================
+func roleMSPs(stub shim.ChaincodeStubInterface) (map[Role][]string, error) {
+	bindings, err := getRoleMSPs(stub)
+	if err != nil {
+		return nil, err
+	}
+	msps := map[Role][]string{}
+	for role, list := range bindings.Roles {
+		msps[role] = list
+	}
+	mspsAsBytes, err := stub.GetState(AdminMSPsKey)
+	if err != nil {
+		return nil, errors.New("Failed to get admin MSPs")
+	}
+	if mspsAsBytes != nil {
+		var admins AdminMSPs
+		if err = decodeDocument(mspsAsBytes, mspMigrations, &admins); err != nil {
+			return nil, fmt.Errorf("Failed to decode admin MSPs: %s", err)
+		}
+		msps[RoleAdmin] = admins.MSPs
+	}
+	return msps, nil
+}
+
+// ============================================================================================================================
+// getRoleMSPs - the stored bindings of the roles other than admin, empty until set_role_msps is called
+// ============================================================================================================================
+func getRoleMSPs(stub shim.ChaincodeStubInterface) (*RoleMSPs, error) {
+	bindings := RoleMSPs{Roles: map[Role][]string{}}
+	rolesAsBytes, err := stub.GetState(RoleMSPsKey)
+	if err != nil {
+		return nil, errors.New("Failed to get role MSPs")
+	}
+	if rolesAsBytes != nil {
+		if err = decodeDocument(rolesAsBytes, mspMigrations, &bindings); err != nil {
+			return nil, fmt.Errorf("Failed to decode role MSPs: %s", err)
+		}
+		if bindings.Roles == nil {
+			bindings.Roles = map[Role][]string{}
+		}
+	}
+	return &bindings, nil
+}
+
+// ============================================================================================================================
+// putAdminMSPs - store the MSP IDs whose admin role is honoured
+// ============================================================================================================================
+func putAdminMSPs(stub shim.ChaincodeStubInterface, msps []string) error {
+	return putDocument(stub, AdminMSPsKey, &AdminMSPs{MSPs: msps})
+}
+
+// ============================================================================================================================
+// getCallerWithRoles - the caller, keeping only the roles its MSP is configured to grant
+// ============================================================================================================================
+func getCallerWithRoles(stub shim.ChaincodeStubInterface) (Caller, error) {
+	caller, err := getCaller(stub)
+	if err != nil || len(caller.Roles) == 0 {
+		return caller, err
+	}
+	msps, err := roleMSPs(stub)
+	if err != nil {
+		return caller, err
+	}
+	roles := []Role{}
+	for _, r := range caller.Roles {
+		if len(msps[r]) == 0 {
+			if !privilegedRoles[r] {
+				roles = append(roles, r)
+			}
+			continue
+		}
+		for _, msp := range msps[r] {
+			if msp == caller.MSPID {
+				roles = append(roles, r)
+				break
+			}
+		}
+	}
+	caller.Roles = roles
+	return caller, nil
+}
+
+// ============================================================================================================================
+// splitMSPs - the MSP IDs of a comma separated list
+// ============================================================================================================================
+func splitMSPs(s string) []string {
+	msps := []string{}
+	for _, msp := range strings.Split(s, ",") {
+		if msp = strings.TrimSpace(msp); msp != "" {
+			msps = append(msps, msp)
+		}
+	}
+	return msps
+}
+
+// ============================================================================================================================
+// set_role_msps - configure the MSPs whose clients may hold a role, an empty list refuses servicers and auditors
+// from every MSP and accepts borrowers and lenders from any
+// ============================================================================================================================
+func (t *ManageLoan) set_role_msps(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting role and the comma separated MSP IDs")
+	}
+	fmt.Println("start set_role_msps")
+	role := parseRoles(args[0])
+	if len(role) != 1 {
+		return nil, invalidField("role", args[0], "expecting borrower, lender, servicer, auditor or admin")
+	}
+	msps := splitMSPs(args[1])
+	if role[0] == RoleAdmin {
+		if len(msps) == 0 {
+			return nil, invalidField("msps", args[1], "the admin role needs at least one MSP")
+		}
+		if err := putAdminMSPs(stub, msps); err != nil {
+			return nil, err
+		}
+		fmt.Println("end set_role_msps")
+		return nil, nil
+	}
+	bindings, err := getRoleMSPs(stub)
+	if err != nil {
+		return nil, err
+	}
+	bindings.Roles[role[0]] = msps
+	if err = putDocument(stub, RoleMSPsKey, bindings); err != nil {
+		return nil, err
+	}
+	fmt.Println("end set_role_msps")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// authorizeAgreement - borrowers and lenders may only touch Agreements they are a party to
+// ============================================================================================================================
+func authorizeAgreement(stub shim.ChaincodeStubInterface, res *Agreement) error {
+	caller, err := getCallerWithRoles(stub)
+	if err != nil {
+		return err
+	}
+	if caller.SeesAll() || caller.IsPartyTo(res) {
+		return nil
+	}
+	return forbidden("Access denied: %s is not a party to Agreement %s", caller.Party, res.AgreeementID)
+}
+
+// ============================================================================================================================
+// visibleAgreements - drop the Agreements the caller is not a party to, unless the caller sees everything
+// ============================================================================================================================
+func visibleAgreements(stub shim.ChaincodeStubInterface, records []*Agreement) ([]*Agreement, error) {
+	caller, err := getCallerWithRoles(stub)
+	if err != nil {
+		return nil, err
+	}
+	if caller.SeesAll() {
+		return records, nil
+	}
+	visible := []*Agreement{}
+	for _, res := range records {
+		if caller.IsPartyTo(res) {
+			visible = append(visible, res)
+		}
+	}
+	return visible, nil
+}
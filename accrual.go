@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// DayCount - how the days between two dates and the days in a year are counted
+type DayCount string
+
+const (
+	DayCountActual365 DayCount = "act/365" // calendar days over a 365 day year
+	DayCountActual360 DayCount = "act/360" // calendar days over a 360 day year
+	DayCount30360     DayCount = "30/360"  // 30 day months over a 360 day year, US bond basis
+)
+
+// InterestMethod - whether accrued interest earns interest itself
+type InterestMethod string
+
+const (
+	InterestSimple   InterestMethod = "simple"   // interest on the outstanding balance only
+	InterestCompound InterestMethod = "compound" // compounded daily over the year of the day count
+)
+
+// accrualScale - fixed-point precision of compound growth factors, 18 decimal places
+var accrualScale = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+
+type Accrual struct { // Interest accrued on an Agreement over one period, returned by accrue_interest and getAccruedInterest
+	AgreementID     string         `json:"agreement_id"`
+	DayCount        DayCount       `json:"day_count"`
+	InterestMethod  InterestMethod `json:"interest_method"`
+	From            string         `json:"from"`
+	To              string         `json:"to"`
+	Days            int            `json:"days"`      // days of the period under the day count
+	Principal       Money          `json:"principal"` // balance the period's interest was computed on
+	Interest        Money          `json:"interest"`  // interest of this period
+	AccruedInterest Money          `json:"accrued_interest"`
+}
+
+// ============================================================================================================================
+// parseDayCount / parseInterestMethod - accept a convention in any letter case, empty means act/365 and simple
+// ============================================================================================================================
+func parseDayCount(s string) (DayCount, error) {
+	switch dc := DayCount(strings.ToLower(strings.TrimSpace(s))); dc {
+	case "":
+		return DayCountActual365, nil
+	case DayCountActual365, DayCountActual360, DayCount30360:
+		return dc, nil
+	case "actual/365":
+		return DayCountActual365, nil
+	case "actual/360":
+		return DayCountActual360, nil
+	}
+	return "", invalidField("day_count", s, "expecting act/365, act/360 or 30/360")
+}
+
+func parseInterestMethod(s string) (InterestMethod, error) {
+	switch method := InterestMethod(strings.ToLower(strings.TrimSpace(s))); method {
+	case "":
+		return InterestSimple, nil
+	case InterestSimple, InterestCompound:
+		return method, nil
+	}
+	return "", invalidField("interest_method", s, "expecting simple or compound")
+}
+
+// ============================================================================================================================
+// dayCountDays - the days from one date to a later one and the days in a year, under a day count
+// ============================================================================================================================
+func dayCountDays(dc DayCount, from, to time.Time) (int, int) {
+	switch dc {
+	case DayCountActual360:
+		return int(to.Sub(from).Hours() / 24), 360
+	case DayCount30360:
+		d1, d2 := from.Day(), to.Day()
+		if d1 == 31 {
+			d1 = 30
+		}
+		if d2 == 31 && d1 == 30 {
+			d2 = 30
+		}
+		return 360*(to.Year()-from.Year()) + 30*(int(to.Month())-int(from.Month())) + d2 - d1, 360
+	}
+	return int(to.Sub(from).Hours() / 24), 365
+}
+
+// ============================================================================================================================
+// mulScaled - multiply two fixed-point numbers, rounding halves up, so every peer gets the same digits
+// ============================================================================================================================
+func mulScaled(a, b *big.Int) *big.Int {
+	product := new(big.Int).Mul(a, b)
+	product.Add(product, new(big.Int).Rsh(accrualScale, 1))
+	return product.Quo(product, accrualScale)
+}
+
+// ============================================================================================================================
+// accruedInterest - interest in minor units on a principal over days of a year at an annual rate, rounded once at the end
+// ============================================================================================================================
+func accruedInterest(principal int64, rate Rate, days, yearDays int, method InterestMethod) int64 {
+	if days <= 0 || principal <= 0 {
+		return 0
+	}
+	if method == InterestSimple { // P * r * days / year, exact
+		interest := new(big.Int).Mul(big.NewInt(principal), big.NewInt(rate.BasisPoints))
+		interest.Mul(interest, big.NewInt(int64(days)))
+		return roundCents(new(big.Rat).SetFrac(interest, big.NewInt(int64(10000*yearDays))))
+	}
+	// P * ((1 + r/year)^days - 1), the daily factor in fixed point raised by squaring
+	daily := new(big.Int).Mul(accrualScale, big.NewInt(rate.BasisPoints))
+	daily.Quo(daily, big.NewInt(int64(10000*yearDays)))
+	daily.Add(daily, accrualScale)
+	growth := new(big.Int).Set(accrualScale)
+	for n := days; n > 0; n >>= 1 {
+		if n&1 == 1 {
+			growth = mulScaled(growth, daily)
+		}
+		daily = mulScaled(daily, daily)
+	}
+	growth.Sub(growth, accrualScale)
+	return roundCents(new(big.Rat).SetFrac(growth.Mul(growth, big.NewInt(principal)), accrualScale))
+}
+
+// ============================================================================================================================
+// accrualStart - the date interest runs from, the last accrual or else the agreement date or else the day the loan went active
+// ============================================================================================================================
+func accrualStart(res *Agreement) (time.Time, error) {
+	if res.AccruedThrough != "" {
+		return time.Parse(DateLayout, res.AccruedThrough)
+	}
+	if res.AgreementDate != "" {
+		start, err := time.Parse(DateLayout, res.AgreementDate)
+		if err != nil {
+			return start, invalidField("agreement_date", res.AgreementDate, "expecting YYYY-MM-DD")
+		}
+		return start, nil
+	}
+	for _, change := range res.StatusHistory {
+		if change.To == StatusActive {
+			activated, err := time.Parse(time.RFC3339, change.Timestamp)
+			if err != nil {
+				return activated, err
+			}
+			return time.Date(activated.Year(), activated.Month(), activated.Day(), 0, 0, 0, 0, time.UTC), nil
+		}
+	}
+	return time.Time{}, invalidField("agreement_date", "", "Agreement "+res.AgreeementID+" has no date to accrue interest from")
+}
+
+// ============================================================================================================================
+// computeAccrual - the interest an Agreement accrues from its last accrual to a date, without storing it
+// ============================================================================================================================
+func computeAccrual(res *Agreement, to time.Time) (Accrual, error) {
+	dc, err := parseDayCount(res.DayCount)
+	if err != nil {
+		return Accrual{}, err
+	}
+	method, err := parseInterestMethod(res.InterestMethod)
+	if err != nil {
+		return Accrual{}, err
+	}
+	if !res.InterestRate.Valid() {
+		return Accrual{}, fmt.Errorf("Agreement %s has an unreadable interest rate %q", res.AgreeementID, res.InterestRate.Raw)
+	}
+	balance, err := outstandingBalance(res)
+	if err != nil {
+		return Accrual{}, err
+	}
+	accrued := balance.Amount(0)
+	if res.AccruedInterest != nil {
+		accrued = *res.AccruedInterest
+	}
+	from, err := accrualStart(res)
+	if err != nil {
+		return Accrual{}, err
+	}
+	if to.Before(from) {
+		if res.AccruedThrough != "" {
+			return Accrual{}, invalidField("as_of_date", to.Format(DateLayout), "interest is already accrued through "+res.AccruedThrough)
+		}
+		to = from //nothing accrues before the loan starts
+	}
+
+	accrual := Accrual{AgreementID: res.AgreeementID, DayCount: dc, InterestMethod: method, From: from.Format(DateLayout), To: to.Format(DateLayout)}
+	accrual.Principal = balance
+	if method == InterestCompound { //interest accrued so far earns interest too
+		accrual.Principal = balance.Amount(balance.MinorUnits + accrued.MinorUnits)
+	}
+	status := currentStatus(res)
+	if outstandingStatuses[status] { //interest only runs while money is lent
+		var yearDays int
+		accrual.Days, yearDays = dayCountDays(dc, from, to)
+		accrual.Interest = balance.Amount(accruedInterest(accrual.Principal.MinorUnits, res.InterestRate, accrual.Days, yearDays, method))
+	} else {
+		accrual.Interest = balance.Amount(0)
+	}
+	accrual.AccruedInterest = balance.Amount(accrued.MinorUnits + accrual.Interest.MinorUnits)
+	return accrual, nil
+}
+
+// ============================================================================================================================
+// accrue - add the interest accrued up to the transaction date to an Agreement, the caller stores it
+// ============================================================================================================================
+func accrue(stub shim.ChaincodeStubInterface, res *Agreement) (Accrual, error) {
+	now, err := txTime(stub) //never the wall clock, every endorser must compute the same figure
+	if err != nil {
+		return Accrual{}, err
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	accrual, err := computeAccrual(res, today)
+	if err != nil {
+		return accrual, err
+	}
+	res.AccruedInterest = &accrual.AccruedInterest
+	if accrual.To == today.Format(DateLayout) { //left alone until the loan starts
+		res.AccruedThrough = accrual.To
+	}
+	return accrual, nil
+}
+
+// ============================================================================================================================
+// accrue_interest - store the interest an active Agreement accrued since the last accrual, up to the transaction date
+// ============================================================================================================================
+func (t *ManageLoan) accrue_interest(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id and an optional expected version")
+	}
+	fmt.Println("start accrue_interest")
+	agreement_id := args[0]
+	res, err := getAgreement(stub, agreement_id)
+	if err != nil {
+		return nil, err
+	}
+	if err = authorizeAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	if err = checkVersion(res, optionalArg(args, 1)); err != nil {
+		return nil, err
+	}
+	status := currentStatus(res)
+	if !outstandingStatuses[status] {
+		return nil, invalidTransition("agreement_status", "Agreement %s is %s, interest accrues on an active, overdue or defaulted loan", agreement_id, status)
+	}
+	accrual, err := accrue(stub, res)
+	if err != nil {
+		return nil, err
+	}
+	if err = putAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	if err = emitEvent(stub, EventInterestAccrued, agreement_id, status, status); err != nil {
+		return nil, err
+	}
+	fmt.Println("end accrue_interest")
+	return json.Marshal(accrual)
+}
+
+// ============================================================================================================================
+// getAccruedInterest - the interest accrued on an Agreement as of a date, the transaction date when left out, without storing it
+// ============================================================================================================================
+func (t *ManageLoan) getAccruedInterest(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id and an optional as of date")
+	}
+	fmt.Println("start getAccruedInterest")
+	agreement_id := args[0]
+	res, err := getAgreement(stub, agreement_id)
+	if err != nil {
+		return nil, err
+	}
+	if err = authorizeAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	var asOf time.Time
+	if as_of_date := optionalArg(args, 1); as_of_date != "" {
+		if asOf, err = time.Parse(DateLayout, as_of_date); err != nil {
+			return nil, invalidField("as_of_date", as_of_date, "expecting YYYY-MM-DD")
+		}
+	} else {
+		now, err := txTime(stub)
+		if err != nil {
+			return nil, err
+		}
+		asOf = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	accrual, err := computeAccrual(res, asOf)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end getAccruedInterest")
+	return json.Marshal(accrual)
+}
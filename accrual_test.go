@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAccruedInterest(t *testing.T) {
+	date := func(s string) time.Time {
+		d, _ := time.Parse(DateLayout, s)
+		return d
+	}
+	cases := []struct {
+		name     string
+		dc       DayCount
+		method   InterestMethod
+		from, to string
+		days     int
+		want     int64
+	}{
+		{"act/365 simple", DayCountActual365, InterestSimple, "2026-01-15", "2026-07-15", 181, 59507},
+		{"act/360 simple", DayCountActual360, InterestSimple, "2026-01-15", "2026-07-15", 181, 60333},
+		{"30/360 simple", DayCount30360, InterestSimple, "2026-01-15", "2026-07-15", 180, 60000},
+		{"act/365 compound", DayCountActual365, InterestCompound, "2026-01-15", "2026-07-15", 181, 61303},
+		{"30/360 compound", DayCount30360, InterestCompound, "2026-01-15", "2026-07-15", 180, 61826},
+		{"30/360 month ends", DayCount30360, InterestSimple, "2026-01-31", "2026-03-31", 60, 20000},
+		{"leap year", DayCountActual365, InterestSimple, "2028-02-01", "2028-03-01", 29, 9534},
+		{"same day", DayCountActual365, InterestCompound, "2026-01-15", "2026-01-15", 0, 0},
+	}
+	rate := Rate{BasisPoints: 1200}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			days, yearDays := dayCountDays(tc.dc, date(tc.from), date(tc.to))
+			if days != tc.days {
+				t.Fatalf("got %d days, want %d", days, tc.days)
+			}
+			if got := accruedInterest(1000000, rate, days, yearDays, tc.method); got != tc.want {
+				t.Errorf("got %d, want %d", got, tc.want)
+			}
+		})
+	}
+
+	// ten years of daily compounding at 25% on a billion, checked against a 50 digit decimal computation
+	if got := accruedInterest(100000000000, Rate{BasisPoints: 2500}, 3650, 365, InterestCompound); got != 1117207296313 {
+		t.Errorf("got %d", got)
+	}
+}
+
+// at - move the ledger clock to a day, the next transaction runs a minute later
+func at(day string) func(c *testChain) {
+	return func(c *testChain) {
+		c.stub.now, _ = time.Parse(DateLayout, day)
+	}
+}
+
+// expectAccrual - check the figures of an accrual
+func expectAccrual(from, to string, days int, interest, accrued int64) func(t *testing.T, c *testChain, payload []byte) {
+	return func(t *testing.T, c *testChain, payload []byte) {
+		var accrual Accrual
+		if err := json.Unmarshal(payload, &accrual); err != nil {
+			t.Fatalf("got %s", payload)
+		}
+		if accrual.From != from || accrual.To != to || accrual.Days != days || accrual.Interest.MinorUnits != interest || accrual.AccruedInterest.MinorUnits != accrued {
+			t.Errorf("got %+v", accrual)
+		}
+	}
+}
+
+func TestAccrueInterest(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "from the agreement date", setup: at("2026-07-15"), caller: "servicer", function: "accrue_interest", args: []string{"A3"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectAccrual("2026-01-15", "2026-07-15", 181, 59507, 59507)(t, c, payload)
+				res := c.agreement("A3")
+				if res.AccruedThrough != "2026-07-15" || res.AccruedInterest.MinorUnits != 59507 {
+					t.Errorf("got %+v", res)
+				}
+				expectEvent(t, c, EventInterestAccrued, "A3")
+			}},
+		{name: "from the last accrual", caller: "bob", function: "accrue_interest", args: []string{"A3"},
+			setup: func(c *testChain) {
+				at("2026-07-15")(c)
+				c.mustInvoke("servicer", "accrue_interest", "A3")
+				at("2026-08-14")(c)
+			},
+			check: expectAccrual("2026-07-15", "2026-08-14", 30, 9863, 59507+9863)},
+		{name: "on the balance left after a repayment", caller: "bob", function: "accrue_interest", args: []string{"A3"},
+			setup: func(c *testChain) {
+				at("2026-07-15")(c)
+				c.mustInvoke("bob", "record_repayment", "A3", "5000", "2026-07-15", "Alice", "r1") //595.07 interest, then 4404.93 principal
+				at("2026-08-14")(c)
+			},
+			check: expectAccrual("2026-07-15", "2026-08-14", 30, 5518, 5518)},
+		{name: "act/360 compound", caller: "servicer", function: "accrue_interest", args: []string{"B1"},
+			setup: func(c *testChain) {
+				c.mustInvoke("alice", "create_agreement", terms("B1", "draft", "", "")...)
+				c.mustInvoke("alice", "update_agreement", "B1", `{"day_count":"Actual/360","interest_method":"compound"}`)
+				c.mustInvoke("bob", "update_status", "B1", "proposed")
+				c.mustInvoke("alice", "borrower_sign", "B1")
+				c.mustInvoke("bob", "lender_sign", "B1")
+				at("2026-07-15")(c)
+			},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var accrual Accrual
+				json.Unmarshal(payload, &accrual)
+				if accrual.DayCount != DayCountActual360 || accrual.InterestMethod != InterestCompound || accrual.Interest.MinorUnits != 62180 {
+					t.Errorf("got %+v", accrual)
+				}
+			}},
+		{name: "before the loan starts", caller: "servicer", function: "accrue_interest", args: []string{"A3"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectAccrual("2026-01-15", "2026-01-15", 0, 0, 0)(t, c, payload)
+				if res := c.agreement("A3"); res.AccruedThrough != "" {
+					t.Errorf("got %+v", res)
+				}
+			}},
+		{name: "draft", caller: "servicer", function: "accrue_interest", args: []string{"A1"}, code: ErrInvalidTransition},
+		{name: "legacy record without a rate", caller: "servicer", function: "accrue_interest", args: []string{"L1"},
+			setup: func(c *testChain) {
+				c.stub.begin(nil)
+				c.stub.PutState("L1", []byte(`{"agreement_id":"L1","borrower_name":"Alice","lender_name":"Bob","agreement_date":"2016-05-01","loan_amount":"5000","agreement_status":"Active","interest_rate":"","loan_duration":"24","repayment_date":"2018-05-01","borrower_signed":"true","lender_signed":"true","comments":""}`))
+				c.stub.commit()
+				if res := c.agreement("L1"); res.InterestRate.Valid() {
+					c.t.Errorf("an empty rate reads as %+v", res.InterestRate)
+				}
+			},
+			code: ErrInternal},
+		{name: "stale version", caller: "servicer", function: "accrue_interest", args: []string{"A3", "1"}, code: ErrConflict},
+		{name: "borrowers cannot", caller: "alice", function: "accrue_interest", args: []string{"A3"}, code: ErrForbidden},
+		{name: "conventions locked once active", caller: "bob", function: "update_agreement", args: []string{"A3", `{"day_count":"30/360"}`},
+			code: ErrInvalidArgument},
+		{name: "unknown day count", caller: "alice", function: "update_agreement", args: []string{"A1", `{"day_count":"act/366"}`},
+			code: ErrInvalidArgument},
+	}, false)
+}
+
+func TestGetAccruedInterest(t *testing.T) {
+	accrued := func(c *testChain) {
+		at("2026-07-15")(c)
+		c.mustInvoke("servicer", "accrue_interest", "A3")
+	}
+	runCases(t, []chainCase{
+		{name: "as of a date", caller: "alice", function: "getAccruedInterest", args: []string{"A3", "2026-07-15"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectAccrual("2026-01-15", "2026-07-15", 181, 59507, 59507)(t, c, payload)
+				if res := c.agreement("A3"); res.AccruedInterest != nil {
+					t.Errorf("a query stored %+v", res)
+				}
+			}},
+		{name: "as of the transaction date", setup: at("2026-02-14"), caller: "alice", function: "getAccruedInterest", args: []string{"A3"},
+			check: expectAccrual("2026-01-15", "2026-02-14", 30, 9863, 9863)},
+		{name: "on top of the stored accrual", setup: accrued, caller: "auditor", function: "getAccruedInterest", args: []string{"A3", "2026-08-14"},
+			check: expectAccrual("2026-07-15", "2026-08-14", 30, 9863, 59507+9863)},
+		{name: "before the stored accrual", setup: accrued, caller: "auditor", function: "getAccruedInterest", args: []string{"A3", "2026-07-01"},
+			code: ErrInvalidArgument},
+		{name: "loan not started", caller: "alice", function: "getAccruedInterest", args: []string{"A1", "2026-07-15"},
+			check: expectAccrual("2026-01-15", "2026-07-15", 0, 0, 0)},
+		{name: "bad date", caller: "alice", function: "getAccruedInterest", args: []string{"A3", "15/07/2026"}, code: ErrInvalidArgument},
+		{name: "not a party", caller: "carol", function: "getAccruedInterest", args: []string{"A3"}, code: ErrForbidden},
+	}, true)
+}
@@ -23,49 +23,76 @@ import (
 "errors"
 "fmt"
 "strconv"
+"strings"
 "encoding/json"
 "time"
 
-"github.com/hyperledger/fabric/core/chaincode/shim"
+"github.com/hyperledger/fabric-chaincode-go/shim"
 )
 
-// ManageLoan example simple Chaincode implementation
+// ManageLoan - the Agreement functions under their original names, served through LoanContract
 type ManageLoan struct {
 }
 
-var LoanIndexStr = "_LoanIndex"				//name for the key/value that will store a list of all known Agreement
+var LoanIndexStr = "_LoanIndex"				//name of the JSON array of all Agreement ids older deployments kept, see migrate_index
 
 type Agreement struct{							// Attributes of a Agreement 
+	Document
 	AgreeementID string `json:"agreement_id"`					
-	BorrowerName string `json:"borrower_name"`
+	BorrowerID string `json:"borrower_id"`						//registered party ids, see register_party
+	LenderID string `json:"lender_id"`
+	BorrowerName string `json:"borrower_name"`					//legal names of the parties when they were bound to the Agreement
 	LenderName string `json:"lender_name"`					
 	AgreementDate string `json:"agreement_date"`
 	AgreementStatus string `json:"agreement_status"`
-	LoanAmount string `json:"loan_amount"`
-	InterestRate string `json:"interest_rate"`
-	LoanDuration string `json:"loan_duration"`
+	LoanAmount Money `json:"loan_amount"`
+	InterestRate Rate `json:"interest_rate"`
+	LoanDuration Duration `json:"loan_duration"`
 	RepaymentDate string `json:"repayment_date"`
 	BorrowerSigned string `json:"borrower_signed"`
 	LenderSigned string `json:"lender_signed"`
 	Comments string `json:"comments"`
+	StatusHistory []StatusChange `json:"status_history,omitempty"`
+	BorrowerSignature *Signature `json:"borrower_signature,omitempty"`
+	LenderSignature *Signature `json:"lender_signature,omitempty"`
+	OutstandingBalance *Money `json:"outstanding_balance,omitempty"`
+	RepaymentType string `json:"repayment_type,omitempty"`
+	DayCount string `json:"day_count,omitempty"`					//act/365 when empty, see accrue_interest
+	InterestMethod string `json:"interest_method,omitempty"`				//simple when empty
+	AccruedInterest *Money `json:"accrued_interest,omitempty"`
+	AccruedThrough string `json:"accrued_through,omitempty"`
+	Archived *Archive `json:"archived,omitempty"`
+	Collateral []string `json:"collateral,omitempty"`						//ids of the collateral pledged to it, see pledge_collateral
+	PendingAmendment string `json:"pending_amendment,omitempty"`				//amendment waiting for the counter-party, see propose_amendment
+	Overdue *Overdue `json:"overdue,omitempty"`						//set once the loan is past due, see check_overdue
+	Version int64 `json:"version"`							//bumped by every write, see checkVersion
 }
 // ============================================================================================================================
 // Main - start the chaincode for Agreement management
 // ============================================================================================================================
 func main() {			
-	err := shim.Start(new(ManageLoan))
+	chaincode, err := newChaincode()
+	if err != nil {
+		fmt.Printf("Error creating Agreement management chaincode: %s", err)
+		return
+	}
+	err = chaincode.Start()
 	if err != nil {
 		fmt.Printf("Error starting Agreement management chaincode: %s", err)
 	}
 }
 // ============================================================================================================================
-// Init - reset all the things
+// Init - reset all the things, called as the init function
 // ============================================================================================================================
 func (t *ManageLoan) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	var msg string
 	var err error
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	if len(args) != 1 && len(args) != 2 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting 1, or 2 with the comma separated admin MSP IDs")
+	}
+	msps, err := roleMSPs(stub)
+	if err != nil {
+		return nil, err
 	}
 	// Initialize the chaincode
 	msg = args[0]
@@ -76,10 +103,19 @@ func (t *ManageLoan) Init(stub shim.ChaincodeStubInterface, function string, arg
 	if err != nil {
 		return nil, err
 	}
-	
-	var empty []string
-	jsonAsBytes, _ := json.Marshal(empty)								//marshal an emtpy array of strings to clear the index
-	err = stub.PutState(LoanIndexStr, jsonAsBytes)
+	admins := msps[RoleAdmin]
+	if len(args) == 2 {
+		if admins = splitMSPs(args[1]); len(admins) == 0 {
+			return nil, invalidField("admin_msps", args[1], "expecting at least one MSP ID")
+		}
+	} else if len(admins) == 0 {								//the legacy form binds the admin role to the deploying MSP
+		caller, err := getCaller(stub)
+		if err != nil {
+			return nil, err
+		}
+		admins = []string{caller.MSPID}
+	}
+	err = putAdminMSPs(stub, admins)							//only these MSPs may hand out the admin role
 	if err != nil {
 		return nil, err
 	}
@@ -87,18 +123,49 @@ func (t *ManageLoan) Init(stub shim.ChaincodeStubInterface, function string, arg
 	return nil, nil
 }
 // ============================================================================================================================
-// Run - Our entry point for Invocations - [LEGACY] obc-peer 4/25/2016
+// Invoke - entry point for the functions that write, see LoanContract
 // ============================================================================================================================
-	func (t *ManageLoan) Run(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-		fmt.Println("run is running " + function)
-		return t.Invoke(stub, function, args)
+	func (t *ManageLoan) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+		fmt.Println("invoke is running " + function)
+	args, jsonForm, err := decodeArguments(KindInvoke, function, args)			//a single JSON object names the arguments, see get_schema
+	if err == nil {
+		var payload []byte
+		payload, err = t.invokeWithEvents(stub, function, args)
+		if !jsonForm {												//legacy positional clients get the bare result
+			if err != nil {
+				return nil, asChaincodeError(err)							//and errors from the catalogue, like everyone else
+			}
+			return payload, nil
+		}
+		return respond(payload, err)
 	}
+	return respond(nil, err)
+}
 // ============================================================================================================================
-// Invoke - Our entry point for Invocations
+// invokeWithEvents - check the access policy, run the function and send its events once it succeeded
 // ============================================================================================================================
-	func (t *ManageLoan) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
-		fmt.Println("invoke is running " + function)
-
+func (t *ManageLoan) invokeWithEvents(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	if _, ok := invokePolicies[function]; !ok {							//unknown names are a bad argument, not a denied one
+		fmt.Println("invoke did not find func: " + function)
+		return nil, invalidField("function", function, "Received unknown function invocation")
+	}
+	if err := authorize(stub, function, invokePolicies); err != nil {				//every function has an access policy
+		return nil, err
+	}
+	events := &eventStub{ChaincodeStubInterface: stub}						//events go out once, when the function succeeds
+	payload, err := t.invoke(events, function, args)
+	if err != nil {
+		return nil, err
+	}
+	if err = events.flush(); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+// ============================================================================================================================
+// invoke - route an Invocation to its function
+// ============================================================================================================================
+func (t *ManageLoan) invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	// Handle different functions
 	if function == "init" {													//initialize the chaincode state, used as reset
 		return t.Init(stub, "init", args)
@@ -106,17 +173,82 @@ func (t *ManageLoan) Init(stub shim.ChaincodeStubInterface, function string, arg
 		return t.create_agreement(stub, args)
 	}else if function == "delete_po" {									// delete a Agreement
 		return t.delete_po(stub, args)
+	}else if function == "restore_agreement" {									//bring an archived Agreement back
+		return t.restore_agreement(stub, args)
+	}else if function == "purge_agreement" {									//remove a never signed draft for good
+		return t.purge_agreement(stub, args)
 	}else if function == "update_po" {									//update a Agreement
 		return t.update_po(stub, args)
+	}else if function == "update_agreement" {									//change some fields of a Agreement
+		return t.update_agreement(stub, args)
+	}else if function == "update_status" {									//move a Agreement through its lifecycle
+		return t.update_status(stub, args)
+	}else if function == "borrower_sign" {									//borrower signs a Agreement
+		return t.borrower_sign(stub, args)
+	}else if function == "lender_sign" {									//lender signs a Agreement
+		return t.lender_sign(stub, args)
+	}else if function == "record_repayment" {									//record money repaid on a Agreement
+		return t.record_repayment(stub, args)
+	}else if function == "accrue_interest" {									//add the interest accrued up to today to a Agreement
+		return t.accrue_interest(stub, args)
+	}else if function == "set_role_msps" {									//bind a role to the MSPs that may grant it
+		return t.set_role_msps(stub, args)
+	}else if function == "propose_amendment" {									//propose new terms for a signed Agreement
+		return t.propose_amendment(stub, args)
+	}else if function == "approve_amendment" {									//counter-party accepts the new terms
+		return t.approve_amendment(stub, args)
+	}else if function == "reject_amendment" {									//counter-party declines, or the proposer withdraws
+		return t.reject_amendment(stub, args)
+	}else if function == "register_party" {									//record a borrower or lender
+		return t.register_party(stub, args)
+	}else if function == "update_party" {									//change a party, KYC reviews included
+		return t.update_party(stub, args)
+	}else if function == "register_collateral" {									//record a collateral asset
+		return t.register_collateral(stub, args)
+	}else if function == "pledge_collateral" {									//secure a Agreement with collateral
+		return t.pledge_collateral(stub, args)
+	}else if function == "release_collateral" {									//free collateral from a Agreement
+		return t.release_collateral(stub, args)
+	}else if function == "check_overdue" {									//flag loans past their repayment date, called by a scheduler
+		return t.check_overdue(stub, args)
+	}else if function == "set_overdue_policy" {									//configure penalty rate, grace period and default
+		return t.set_overdue_policy(stub, args)
+	}else if function == "migrate_index" {									//move a legacy _LoanIndex deployment to composite key indexes
+		return t.migrate_index(stub, args)
 	}
 	fmt.Println("invoke did not find func: " + function)					//error
-	return nil, errors.New("Received unknown function invocation")
+	return nil, invalidField("function", function, "Received unknown function invocation")
 }
 // ============================================================================================================================
-// Query - Our entry point for Queries
+// Query - entry point for the functions that only read, see LoanContract
 // ============================================================================================================================
 func (t *ManageLoan) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	fmt.Println("query is running " + function)
+	args, jsonForm, err := decodeArguments(KindQuery, function, args)			//a single JSON object names the arguments, see get_schema
+	if err == nil {
+		var payload []byte
+		payload, err = t.query(stub, function, args)
+		if !jsonForm {												//legacy positional clients get the bare result
+			if err != nil {
+				return nil, asChaincodeError(err)							//and errors from the catalogue, like everyone else
+			}
+			return payload, nil
+		}
+		return respond(payload, err)
+	}
+	return respond(nil, err)
+}
+// ============================================================================================================================
+// query - check the access policy and route a Query to its function
+// ============================================================================================================================
+func (t *ManageLoan) query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	if _, ok := queryPolicies[function]; !ok {								//unknown names are a bad argument, not a denied one
+		fmt.Println("query did not find func: " + function)
+		return nil, invalidField("function", function, "Received unknown function query")
+	}
+	if err := authorize(stub, function, queryPolicies); err != nil {				//every function has an access policy
+		return nil, err
+	}
 
 	// Handle different functions
 	if function == "getAgreement_byID" {													//Read a Agreement by agreeMent_id
@@ -127,287 +259,254 @@ func (t *ManageLoan) Query(stub shim.ChaincodeStubInterface, function string, ar
 		return t.getAgreement_bySeller(stub, args)
 	} else if function == "get_AllAgreement" {													//Read all Agreements
 		return t.get_AllAgreement(stub, args)
+	} else if function == "getRepayments_byAgreement" {													//Read the repayment history of a Agreement
+		return t.getRepayments_byAgreement(stub, args)
+	} else if function == "getSchedule" {													//Read the installment schedule of a Agreement
+		return t.getSchedule(stub, args)
+	} else if function == "getAccruedInterest" {													//Read the interest accrued on a Agreement as of a date
+		return t.getAccruedInterest(stub, args)
+	} else if function == "getAmendments_byAgreement" {													//Read the amendments of a Agreement
+		return t.getAmendments_byAgreement(stub, args)
+	} else if function == "getParty_byID" {													//Read a registered party
+		return t.getParty_byID(stub, args)
+	} else if function == "getCollateral_byID" {													//Read a collateral asset
+		return t.getCollateral_byID(stub, args)
+	} else if function == "getLoanToValue" {													//Read the loan to value of a Agreement
+		return t.getLoanToValue(stub, args)
+	} else if function == "getOverduePolicy" {													//Read the policy check_overdue applies
+		return t.getOverduePolicy(stub, args)
+	} else if function == "getAgreement_byStatus" {													//Read Agreements in a lifecycle status
+		return t.getAgreement_byStatus(stub, args)
+	} else if function == "getAgreement_byRepaymentDate" {													//Read Agreements due between two dates
+		return t.getAgreement_byRepaymentDate(stub, args)
+	} else if function == "query_agreements" {													//Read Agreements matching a structured filter
+		return t.query_agreements(stub, args)
+	} else if function == "getAgreement_history" {													//Read every version of a Agreement
+		return t.getAgreement_history(stub, args)
+	} else if function == "get_schema" {													//Read the argument schema of the JSON form
+		return t.get_schema(stub, args)
 	}
 	fmt.Println("query did not find func: " + function)						//error
-	return nil, errors.New("Received unknown function query")
+	return nil, invalidField("function", function, "Received unknown function query")
 }
 // ============================================================================================================================
 // getAgreement_byID - get Agreement details for a specific ID from chaincode state
 // ============================================================================================================================
 func (t *ManageLoan) getAgreement_byID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var agreement_id, jsonResp string
-	var err error
+	var agreement_id string
 	fmt.Println("start getAgreement_byID")
 	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting ID of the var to query")
+		return nil, invalidArgs("Incorrect number of arguments. Expecting ID of the var to query")
 	}
 	// set agreement_id
 	agreement_id = args[0]
-	valAsbytes, err := stub.GetState(agreement_id)									//get the agreement_id from chaincode state
+	res, err := getAgreement(stub, agreement_id)									//get the agreement_id from chaincode state, upgraded to the current schema
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + agreement_id + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, err
+	}
+	if err = authorizeAgreement(stub, res); err != nil {
+		return nil, err
 	}
-	//fmt.Print("valAsbytes : ")
-	//fmt.Println(valAsbytes)
 	fmt.Println("end getAgreement_byID")
-	return valAsbytes, nil													//send it onward
+	return json.Marshal(res)													//send it onward
 }
 // ============================================================================================================================
-//  getAgreement_byBuyer - get Agreement details by buyer's name from chaincode state
+//  getAgreement_byBuyer - get Agreement details by buyer's party id from chaincode state
 // ============================================================================================================================
 func (t *ManageLoan) getAgreement_byBuyer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp, lender_name, errResp string
-	var poIndex []string
-	var valIndex Agreement
 	fmt.Println("start getAgreement_byBuyer")
-	var err error
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1 argument")
+	if len(args) < 1 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting party id, optional page size, bookmark and include archived flag")
 	}
-	// set buyer's name
-	lender_name = args[0]
-	//fmt.Println("lender_name" + lender_name)
-	poAsBytes, err := stub.GetState(LoanIndexStr)
+	// set buyer's party id
+	lender_id := args[0]
+	paging, err := parsePaging(args[1:])
 	if err != nil {
-		return nil, errors.New("Failed to get Agreement index string")
-	}
-	//fmt.Print("poAsBytes : ")
-	//fmt.Println(poAsBytes)
-	json.Unmarshal(poAsBytes, &poIndex)								//un stringify it aka JSON.parse()
-	//fmt.Print("poIndex : ")
-	//fmt.Println(poIndex)
-	//fmt.Println("len(poIndex) : ")
-	//fmt.Println(len(poIndex))
-	jsonResp = "{"
-	for i,val := range poIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for getAgreement_byBuyer")
-		valueAsBytes, err := stub.GetState(val)
-		if err != nil {
-			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
-		}
-		//fmt.Print("valueAsBytes : ")
-		//fmt.Println(valueAsBytes)
-		json.Unmarshal(valueAsBytes, &valIndex)
-		//fmt.Print("valIndex: ")
-		//fmt.Print(valIndex)
-		if valIndex.LenderName == lender_name{
-			fmt.Println("Buyer found")
-			jsonResp = jsonResp + "\""+ val + "\":" + string(valueAsBytes[:])
-			//fmt.Println("jsonResp inside if")
-			//fmt.Println(jsonResp)
-			if i < len(poIndex)-1 {
-				jsonResp = jsonResp + ","
-			}
-		}
-		
+		return nil, err
 	}
-	jsonResp = jsonResp + "}"
-	//fmt.Println("jsonResp : " + jsonResp)
-	//fmt.Print("jsonResp in bytes : ")
-	//fmt.Println([]byte(jsonResp))
 	fmt.Println("end getAgreement_byBuyer")
-	return []byte(jsonResp), nil											//send it onward
+	return agreementPage(stub, LenderIndex, []string{lender_id}, paging)			//only the buyer's Agreements are read
 }
 
 // ============================================================================================================================
 //  getAgreement_bySeller - get Agreement details for a specific Seller from chaincode state
 // ============================================================================================================================
 func (t *ManageLoan) getAgreement_bySeller(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp, borrower_name, errResp string
-	var poIndex []string
-	var valIndex Agreement
 	fmt.Println("start getAgreement_bySeller")
-	var err error
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1 argument")
+	if len(args) < 1 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting party id, optional page size, bookmark and include archived flag")
 	}
-	// set seller name
-	borrower_name = args[0]
-	//fmt.Println("lender_name" + borrower_name)
-	poAsBytes, err := stub.GetState(LoanIndexStr)
+	// set seller party id
+	borrower_id := args[0]
+	paging, err := parsePaging(args[1:])
 	if err != nil {
-		return nil, errors.New("Failed to get Agreement index")
-	}
-	//fmt.Print("poAsBytes : ")
-	//fmt.Println(poAsBytes)
-	json.Unmarshal(poAsBytes, &poIndex)								//un stringify it aka JSON.parse()
-	//fmt.Print("poIndex : ")
-	//fmt.Println(poIndex)
-	//fmt.Println("len(poIndex) : ")
-	//fmt.Println(len(poIndex))
-	jsonResp = "{"
-	for i,val := range poIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for getting borrower_name")
-		valueAsBytes, err := stub.GetState(val)
-		if err != nil {
-			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
-		}
-		//fmt.Print("valueAsBytes : ")
-		//fmt.Println(valueAsBytes)
-		json.Unmarshal(valueAsBytes, &valIndex)
-		//fmt.Print("valIndex: ")
-		//fmt.Print(valIndex)
-		if valIndex.BorrowerName == borrower_name{
-			fmt.Println("Seller found")
-			jsonResp = jsonResp + "\""+ val + "\":" + string(valueAsBytes[:])
-			//fmt.Println("jsonResp inside if")
-			//fmt.Println(jsonResp)
-			if i < len(poIndex)-1 {
-				jsonResp = jsonResp + ","
-			}
-		}
-		
+		return nil, err
 	}
-	
-	jsonResp = jsonResp + "}"
-	//fmt.Println("jsonResp : " + jsonResp)
-	//fmt.Print("jsonResp in bytes : ")
-	//fmt.Println([]byte(jsonResp))
 	fmt.Println("end getAgreement_bySeller")
-	return []byte(jsonResp), nil											//send it onward
+	return agreementPage(stub, BorrowerIndex, []string{borrower_id}, paging)			//only the seller's Agreements are read
 }
 // ============================================================================================================================
-//  get_AllAgreement- get details of all Agreement from chaincode state
+//  get_AllAgreement- get one page of all Agreement from chaincode state
 // ============================================================================================================================
 func (t *ManageLoan) get_AllAgreement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp, errResp string
-	var poIndex []string
 	fmt.Println("start get_AllAgreement")
-	var err error
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1 argument")
+	if len(args) > 0 && args[0] != "" {
+		if _, err := strconv.Atoi(args[0]); err != nil {
+			args = args[1:]										//the dummy argument of older clients, not a page size
+		}
 	}
-	poAsBytes, err := stub.GetState(LoanIndexStr)
+	paging, err := parsePaging(args)							//an empty page size means the default
 	if err != nil {
-		return nil, errors.New("Failed to get Agreement index")
-	}
-	//fmt.Print("poAsBytes : ")
-	//fmt.Println(poAsBytes)
-	json.Unmarshal(poAsBytes, &poIndex)								//un stringify it aka JSON.parse()
-	//fmt.Print("poIndex : ")
-	//fmt.Println(poIndex)
-	jsonResp = "{"
-	for i,val := range poIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for all Agreement")
-		valueAsBytes, err := stub.GetState(val)
-		if err != nil {
-			errResp = "{\"Error\":\"Failed to get state for " + val + "\"}"
-			return nil, errors.New(errResp)
-		}
-		//fmt.Print("valueAsBytes : ")
-		//fmt.Println(valueAsBytes)
-		jsonResp = jsonResp + "\""+ val + "\":" + string(valueAsBytes[:])
-		if i < len(poIndex)-1 {
-			jsonResp = jsonResp + ","
-		}
+		return nil, err
 	}
-	//fmt.Println("len(poIndex) : ")
-	//fmt.Println(len(poIndex))
-	jsonResp = jsonResp + "}"
-	//fmt.Println("jsonResp : " + jsonResp)
-	//fmt.Print("jsonResp in bytes : ")
-	//fmt.Println([]byte(jsonResp))
 	fmt.Println("end get_AllAgreement")
-	return []byte(jsonResp), nil
-											//send it onward
+	return agreementPage(stub, AgreementIndex, []string{}, paging)					//send it onward
 }
 // ============================================================================================================================
-// Delete - remove a Agreement from chain
+// Delete - archive a Agreement with a reason, cancelling it first when the loan has not started, see purge_agreement
 // ============================================================================================================================
 func (t *ManageLoan) delete_po(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 1")
+	if len(args) != 2 && len(args) != 3 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id, reason and an optional expected version")
 	}
 	// set agreement_id
 	agreement_id := args[0]
-	err := stub.DelState(agreement_id)													//remove the Agreement from chaincode
+	reason := strings.TrimSpace(args[1])
+	if reason == "" {
+		return nil, invalidField("reason", args[1], "must not be empty")
+	}
+	res, err := getAgreement(stub, agreement_id)
 	if err != nil {
-		return nil, errors.New("Failed to delete state")
+		return nil, err
 	}
-
-	//get the Agreement index
-	poAsBytes, err := stub.GetState(LoanIndexStr)
+	err = checkVersion(res, optionalArg(args, 2))
 	if err != nil {
-		return nil, errors.New("Failed to get Agreement index")
-	}
-	//fmt.Println("poAsBytes in delete po")
-	//fmt.Println(poAsBytes);
-	var poIndex []string
-	json.Unmarshal(poAsBytes, &poIndex)								//un stringify it aka JSON.parse()
-	//fmt.Println("poIndex in delete po")
-	//fmt.Println(poIndex);
-	//remove marble from index
-	for i,val := range poIndex{
-		fmt.Println(strconv.Itoa(i) + " - looking at " + val + " for " + agreement_id)
-		if val == agreement_id{															//find the correct Agreement
-			fmt.Println("found Agreement with matching agreement_id")
-			poIndex = append(poIndex[:i], poIndex[i+1:]...)			//remove it
-			for x:= range poIndex{											//debug prints...
-				fmt.Println(string(x) + " - " + poIndex[x])
-			}
-			break
+		return nil, err
+	}
+	if res.Archived != nil {
+		return nil, invalidTransition("archived", "Agreement %s is already archived", agreement_id)
+	}
+	from := currentStatus(res)
+	if outstandingStatuses[from] {								//money is still owed, the loan stays on the book
+		return nil, invalidTransition("agreement_status", "Agreement %s is %s with money outstanding and cannot be archived", agreement_id, from)
+	}
+	if canTransition(from, StatusCancelled) {
+		err = transition(stub, res, StatusCancelled)
+		if err != nil {
+			return nil, err
 		}
 	}
-	jsonAsBytes, _ := json.Marshal(poIndex)									//save new index
-	err = stub.PutState(LoanIndexStr, jsonAsBytes)
+	err = archiveAgreement(stub, res, reason)
+	if err != nil {
+		return nil, err
+	}
+	err = putAgreement(stub, res)													//the record and its index entries stay, flagged as archived
+	if err != nil {
+		return nil, err
+	}
+	err = emitEvent(stub, EventAgreementArchived, agreement_id, from, currentStatus(res))
+	if err != nil {
+		return nil, err
+	}
 	return nil, nil
 }
 // ============================================================================================================================
 // Write - update Agreement into chaincode state
 // ============================================================================================================================
 func (t *ManageLoan) update_po(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	var jsonResp string
-	var err error
 	fmt.Println("start update_po")
-	if len(args) != 12 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 12.")
+	if len(args) != 12 && len(args) != 13 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting 12, or 13 with the expected version.")
 	}
 	// set agreement_id
 	agreement_id := args[0]
-	poAsBytes, err := stub.GetState(agreement_id)									//get the Agreement for the specified agreement_id from chaincode state
+	res, err := getAgreement(stub, agreement_id)									//get the Agreement for the specified agreement_id from chaincode state
 	if err != nil {
-		jsonResp = "{\"Error\":\"Failed to get state for " + agreement_id + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, err												//no empty record for an unknown id, its index entries would point nowhere
+	}
+	if err = authorizeAgreement(stub, res); err != nil {
+		return nil, err
 	}
-	//fmt.Print("poAsBytes in update po")
-	//fmt.Println(poAsBytes);
-	res := Agreement{}
-	json.Unmarshal(poAsBytes, &res)
+	if err = checkVersion(res, optionalArg(args, 12)); err != nil {				//someone else wrote since the client read it
+		return nil, err
+	}
+	old_status := currentStatus(res)
 	if res.AgreeementID == agreement_id{
 		fmt.Println("Agreement found with agreement_id : " + agreement_id)
 		//fmt.Println(res);
-		res.BorrowerName = args[1]
-		res.LenderName = args[2]
-		res.AgreementDate = args[3]
-		res.LoanAmount = args[4]
-		res.AgreementStatus = args[5]
-		res.InterestRate = args[6]
-		res.LoanDuration = args[7]
-		res.RepaymentDate = args[8]
-		res.BorrowerSigned = args[9]
-		res.LenderSigned = args[10]
-		res.Comments = args[10]		
+		status, err := parseStatus(args[5])
+		if err != nil {
+			return nil, err
+		}
+		if awaitingSignatures(res) {									//terms out for signing can only be cancelled
+			if status != StatusCancelled {
+				return nil, invalidTransition("agreement_status", "Agreement %s is awaiting signatures and can only be cancelled", agreement_id)
+			}
+			err = transition(stub, res, status)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			if status != currentStatus(res) {
+				err = manualTransition(stub, res, status)					//reject transitions the lifecycle does not allow
+				if err != nil {
+					return nil, err
+				}
+			}
+			loan_amount, err := ParseMoney("loan_amount", args[4])
+			if err != nil {
+				return nil, err
+			}
+			interest_rate, err := ParseRate("interest_rate", args[6])
+			if err != nil {
+				return nil, err
+			}
+			loan_duration, err := ParseDuration("loan_duration", args[7])
+			if err != nil {
+				return nil, err
+			}
+			if old_status != StatusDraft {								//signed terms change through propose_amendment only
+				terms := [][]string{
+					{"borrower_id", args[1], args[1], res.BorrowerID},
+					{"lender_id", args[2], args[2], res.LenderID},
+					{"agreement_date", args[3], args[3], res.AgreementDate},
+					{"loan_amount", args[4], loan_amount.String(), res.LoanAmount.String()},
+					{"interest_rate", args[6], interest_rate.String(), res.InterestRate.String()},
+					{"loan_duration", args[7], loan_duration.String(), res.LoanDuration.String()},
+					{"repayment_date", args[8], args[8], res.RepaymentDate},
+				}
+				for _, term := range terms {
+					if term[2] != term[3] {
+						return nil, invalidField(term[0], term[1], fmt.Sprintf("locked while the Agreement is %s, terms only change in draft or through propose_amendment", old_status))
+					}
+				}
+			}
+			if args[1] != res.BorrowerID || args[2] != res.LenderID {
+				res.BorrowerID = args[1]
+				res.LenderID = args[2]
+				if err = bindParties(stub, res); err != nil {				//a new party must be registered and approved
+					return nil, err
+				}
+			}
+			res.AgreementDate = args[3]
+			res.LoanAmount = loan_amount
+			res.InterestRate = interest_rate
+			res.LoanDuration = loan_duration
+			if args[8] != "" {
+				if _, err = time.Parse(DateLayout, args[8]); err != nil {
+					return nil, invalidField("repayment_date", args[8], "expecting YYYY-MM-DD")
+				}
+			}
+			res.RepaymentDate = args[8]
+			res.Comments = args[11]								//args[9] and args[10] are ignored, parties sign with borrower_sign/lender_sign
+		}
 	}
 	
-	//build the Agreement json string manually
-	order := 	`{`+
-		`"agreement_id": "` + res.AgreeementID + `" , `+
-		`"borrower_name": "` + res.BorrowerName + `" , `+
-		`"lender_name": "` + res.LenderName + `" , `+
-		`"agreement_date": "` + res.AgreementDate + `" , `+ 
-		`"loan_amount": "` + res.LoanAmount + `" , `+ 
-		`"agreement_status": "` + res.AgreementStatus + `" , `+ 
-		`"interest_rate": "` + res.InterestRate + `" , `+ 
-		`"loan_duration": "` + res.LoanDuration + `" , `+ 
-		`"repayment_date": "` + res.RepaymentDate + `" , `+ 
-		`"borrower_signed": "` + res.BorrowerSigned + `" , `+ 
-		`"lender_signed": "` +  res.LenderSigned + `" , `+ 
-		`"comments": "` +  res.Comments + `" `+ 
-		`}`
-	err = stub.PutState(agreement_id, []byte(order))									//store Agreement with id as key
+	err = putAgreement(stub, res)									//store Agreement with id as key, moving its index entries
+	if err != nil {
+		return nil, err
+	}
+	err = emitEvent(stub, EventAgreementUpdated, agreement_id, old_status, currentStatus(res))
 	if err != nil {
 		return nil, err
 	}
@@ -418,110 +517,94 @@ func (t *ManageLoan) update_po(stub shim.ChaincodeStubInterface, args []string)
 // ============================================================================================================================
 func (t *ManageLoan) create_agreement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var err error
-	if len(args) != 12 {
-		return nil, errors.New("Incorrect number of arguments. Expecting 12")
+	if len(args) != 12 && len(args) != 13 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting 12, or 13 with the repayment type")
 	}
 	fmt.Println("start create_agreement")
 
 	agreement_id := args[0]
-	borrower_name := args[1]
-	lender_name := args[2]
+	borrower_id := args[1]
+	lender_id := args[2]
 	agreement_date := args[3]
-	loan_amount := args[4]
+	loan_amount, err := ParseMoney("loan_amount", args[4])
+	if err != nil {
+		return nil, err
+	}
 	agreement_status := args[5]
-	interest_rate := args[6]
-	loan_duration := args[7]
+	interest_rate, err := ParseRate("interest_rate", args[6])
+	if err != nil {
+		return nil, err
+	}
+	loan_duration, err := ParseDuration("loan_duration", args[7])
+	if err != nil {
+		return nil, err
+	}
 	repayment_date := args[8]
-	borrower_signed := args[9]
-	lender_signed := args[10]
-	comments := args[11]
+	if repayment_date != "" {
+		if _, err = time.Parse(DateLayout, repayment_date); err != nil {		//dates are indexed, they must sort
+			return nil, invalidField("repayment_date", repayment_date, "expecting YYYY-MM-DD")
+		}
+	}
+	comments := args[11]										//args[9] and args[10] are ignored, parties sign with borrower_sign/lender_sign
+	repayment_type := RepaymentAnnuity
+	if len(args) == 13 {
+		repayment_type, err = parseRepaymentType(args[12])
+		if err != nil {
+			return nil, err
+		}
+	}
 	
 	poAsBytes, err := stub.GetState(agreement_id)
 	if err != nil {
 		return nil, errors.New("Failed to get Agreement transID")
 	}
-	//fmt.Print("poAsBytes: ")
-	//fmt.Println(poAsBytes)
-	res := Agreement{}
-	json.Unmarshal(poAsBytes, &res)
-	//fmt.Print("res: ")
-	//fmt.Println(res)
-	if res.AgreeementID == agreement_id{
+	if poAsBytes != nil{
 		//fmt.Println("This Agreement arleady exists: " + agreement_id)
 		//fmt.Println(res);
-		return nil, errors.New("This Agreement arleady exists")				//all stop a Agreement by this name exists
+		return nil, alreadyExists("agreement_id", agreement_id, "This Agreement arleady exists")				//all stop a Agreement by this name exists
 	}
 	
-	//build the Agreement json string manually
-	order := 	`{`+
-		`"agreement_id": "` + agreement_id + `" , `+
-		`"borrower_name": "` + borrower_name + `" , `+
-		`"lender_name": "` + lender_name + `" , `+
-		`"agreement_date": "` + agreement_date + `" , `+ 
-		`"loan_amount": "` + loan_amount + `" , `+ 
-		`"agreement_status": "` + agreement_status + `" , `+ 
-		`"interest_rate": "` + interest_rate + `" , `+ 
-		`"loan_duration": "` + loan_duration + `" , `+ 
-		`"repayment_date": "` + repayment_date + `" , `+ 
-		`"borrower_signed": "` + borrower_signed + `" , `+ 
-		`"lender_signed": "` +  lender_signed + `" , `+
-		`"comments": "` +  comments + `" `+ 
-		`}`
-		//fmt.Println("order: " + order)
-		fmt.Print("order in bytes array: ")
-		fmt.Println([]byte(order))
-	err = stub.PutState(agreement_id, []byte(order))									//store Agreement with agreement_id as key
+	//new Agreements start in draft unless they are proposed right away
+	status := StatusDraft
+	if agreement_status != "" {
+		status, err = parseStatus(agreement_status)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !isInitialStatus(status) {
+		return nil, invalidField("agreement_status", agreement_status, "A new Agreement must start as draft or proposed")
+	}
+	res := Agreement{
+		AgreeementID: agreement_id,
+		BorrowerID: borrower_id,
+		LenderID: lender_id,
+		AgreementDate: agreement_date,
+		LoanAmount: loan_amount,
+		InterestRate: interest_rate,
+		LoanDuration: loan_duration,
+		RepaymentDate: repayment_date,
+		Comments: comments,
+		RepaymentType: string(repayment_type),
+	}
+	if err = bindParties(stub, &res); err != nil {							//both parties registered and KYC approved
+		return nil, err
+	}
+	if err = authorizeAgreement(stub, &res); err != nil {						//borrowers and lenders only create their own Agreements
+		return nil, err
+	}
+	err = recordStatus(stub, &res, "", status)
 	if err != nil {
 		return nil, err
 	}
-	//get the Agreement index
-	poIndexAsBytes, err := stub.GetState(LoanIndexStr)
+	err = putAgreement(stub, &res)									//store Agreement with agreement_id as key
 	if err != nil {
-		return nil, errors.New("Failed to get Agreement index")
+		return nil, err
 	}
-	var poIndex []string
-	//fmt.Print("poIndexAsBytes: ")
-	//fmt.Println(poIndexAsBytes)
-	
-	json.Unmarshal(poIndexAsBytes, &poIndex)							//un stringify it aka JSON.parse()
-	//fmt.Print("poIndex after unmarshal..before append: ")
-	//fmt.Println(poIndex)
-	//append
-	poIndex = append(poIndex, agreement_id)									//add Agreement transID to index list
-	//fmt.Println("! Agreement index after appending agreement_id: ", poIndex)
-	jsonAsBytes, _ := json.Marshal(poIndex)
-	//fmt.Print("jsonAsBytes: ")
-	//fmt.Println(jsonAsBytes)
-	err = stub.PutState(LoanIndexStr, jsonAsBytes)						//store name of Agreement
+	err = emitEvent(stub, EventAgreementCreated, agreement_id, "", status)
 	if err != nil {
 		return nil, err
 	}
 	fmt.Println("end create_agreement")
-	
-	fmt.Println("start timer")
-	
-	timer := NewTimer(10, func() {
-    		fmt.Printf("Congratulations! Your %d second timer finished.", 10)
-  	})
-  	timer.Stop()
-	
-	fmt.Println("end timer")
-
-	
 	return nil, nil
 }
-
-func NewTimer(seconds int, action func()) *time.Timer {
-	timer := time.NewTimer(time.Second * time.Duration(seconds))
-
-	go func() {
-		<-timer.C
-		action()
-	}()
-
-	return timer
-}
-
-func action () {
-	fmt.Println("check agreement")	
-}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// testChain - a ManageLoan on a memStub with a creator for each test identity
+type testChain struct {
+	t     *testing.T
+	stub  *memStub
+	loans *ManageLoan
+	ids   map[string][]byte
+}
+
+func newTestChain(t *testing.T) *testChain {
+	return &testChain{
+		t:     t,
+		stub:  newMemStub(),
+		loans: new(ManageLoan),
+		ids: map[string][]byte{
+			"admin":    newIdentity(t, "Org1MSP", "admin", "admin"),
+			"alice":    newIdentity(t, "Org1MSP", "alice", "borrower"),
+			"bob":      newIdentity(t, "Org2MSP", "bob", "lender"),
+			"carol":    newIdentity(t, "Org1MSP", "carol", "borrower"),
+			"servicer": newIdentity(t, "Org2MSP", "servicer", "servicer"),
+			"auditor":  newIdentity(t, "Org1MSP", "auditor", "auditor"),
+			"mallory":  newIdentity(t, "Org3MSP", "mallory", "admin"), //admin role from an MSP that may not grant it
+		},
+	}
+}
+
+// invoke - submit a transaction as who, committing its writes when it succeeds
+func (c *testChain) invoke(who, function string, args ...string) ([]byte, error) {
+	c.stub.begin(c.ids[who])
+	payload, err := c.loans.Invoke(c.stub, function, args)
+	if err == nil {
+		c.stub.commit()
+	}
+	return payload, err
+}
+
+// query - evaluate a read as who
+func (c *testChain) query(who, function string, args ...string) ([]byte, error) {
+	c.stub.begin(c.ids[who])
+	return c.loans.Query(c.stub, function, args)
+}
+
+func (c *testChain) mustInvoke(who, function string, args ...string) []byte {
+	c.t.Helper()
+	payload, err := c.invoke(who, function, args...)
+	if err != nil {
+		c.t.Fatalf("%s %s %v: %v", who, function, args, err)
+	}
+	return payload
+}
+
+// agreement - the committed Agreement, read past the access policies
+func (c *testChain) agreement(agreement_id string) *Agreement {
+	c.t.Helper()
+	res, err := decodeAgreement(agreement_id, c.stub.state[agreement_id])
+	if err != nil {
+		c.t.Fatalf("read %s: %v", agreement_id, err)
+	}
+	return res
+}
+
+// terms - positional create_agreement and update_po arguments between Alice and Bob
+func terms(agreement_id, status, repayment_date, comments string) []string {
+	return []string{agreement_id, "Alice", "Bob", "2026-01-15", "10000 USD", status, "12", "12", repayment_date, "", "", comments}
+}
+
+// contactHash - a contact hash for register_party
+var contactHash = strings.Repeat("ab", 32)
+
+// seededParties - party id, legal name, type and bound identity of the KYC approved parties of seededChain
+var seededParties = [][]string{
+	{"Alice", "Alice Moreau", "individual", "Org1MSP", "alice"},
+	{"Bob", "Bob Capital Ltd", "organization", "Org2MSP", "bob"},
+	{"Carol", "Carol Dupont", "individual", "Org1MSP", "carol"},
+}
+
+// seededChain - an initialized ledger with the seeded parties, A1 in draft, A2 proposed and A3 signed by both parties and active
+func seededChain(t *testing.T) *testChain {
+	c := newTestChain(t)
+	c.mustInvoke("admin", "init", "hello", "Org1MSP")
+	c.mustInvoke("admin", "set_role_msps", "servicer", "Org2MSP")
+	c.mustInvoke("admin", "set_role_msps", "auditor", "Org1MSP")
+	for _, p := range seededParties {
+		c.mustInvoke("admin", "register_party", append(p, contactHash)...)
+		c.mustInvoke("servicer", "update_party", p[0], `{"kyc_status":"approved"}`)
+	}
+	c.mustInvoke("alice", "create_agreement", terms("A1", "draft", "2027-01-15", "first")...)
+	c.mustInvoke("alice", "create_agreement", terms("A2", "proposed", "2027-02-15", "second")...)
+	c.mustInvoke("bob", "create_agreement", terms("A3", "proposed", "2027-03-15", "third")...)
+	c.mustInvoke("alice", "borrower_sign", "A3")
+	c.mustInvoke("bob", "lender_sign", "A3")
+	return c
+}
+
+// errorCode - the catalogue code of an error, or of the Response envelope of a JSON form call, empty for success
+func errorCode(err error) ErrorCode {
+	if err == nil {
+		return ""
+	}
+	var response Response
+	if json.Unmarshal([]byte(err.Error()), &response) == nil && response.Error != nil {
+		return response.Error.Code
+	}
+	return asChaincodeError(err).Code
+}
+
+type chainCase struct {
+	name     string
+	setup    func(c *testChain) // runs on the seeded chain before the call
+	caller   string
+	function string
+	args     []string
+	code     ErrorCode // expected error code, empty when the call succeeds
+	check    func(t *testing.T, c *testChain, payload []byte)
+}
+
+// runCases - run every case against its own seeded chain, as an invoke or as a query
+func runCases(t *testing.T, cases []chainCase, query bool) {
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := seededChain(t)
+			if tc.setup != nil {
+				tc.setup(c)
+			}
+			call := c.invoke
+			if query {
+				call = c.query
+			}
+			payload, err := call(tc.caller, tc.function, tc.args...)
+			if code := errorCode(err); code != tc.code {
+				t.Fatalf("got code %q (%v), want %q", code, err, tc.code)
+			}
+			if err == nil && tc.check != nil {
+				tc.check(t, c, payload)
+			}
+		})
+	}
+}
+
+// expectStatus - check the committed status of an Agreement
+func expectStatus(agreement_id string, want LoanStatus) func(t *testing.T, c *testChain, payload []byte) {
+	return func(t *testing.T, c *testChain, payload []byte) {
+		if got := currentStatus(c.agreement(agreement_id)); got != want {
+			t.Errorf("%s is %s, want %s", agreement_id, got, want)
+		}
+	}
+}
+
+// expectEvent - find an event of the last committed transaction, alone or in a batch
+func expectEvent(t *testing.T, c *testChain, eventType EventType, agreement_id string) AgreementEvent {
+	t.Helper()
+	ev := c.stub.lastEvent()
+	if ev == nil || ev.TxId != c.stub.txID {
+		t.Fatalf("got event %+v, want %s in %s", ev, eventType, c.stub.txID)
+	}
+	var events []AgreementEvent
+	if ev.EventName == EventBatchName {
+		if err := json.Unmarshal(ev.Payload, &events); err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		var single AgreementEvent
+		if err := json.Unmarshal(ev.Payload, &single); err != nil {
+			t.Fatal(err)
+		}
+		if ev.EventName != string(single.EventType) {
+			t.Errorf("event %s carries a %s payload", ev.EventName, single.EventType)
+		}
+		events = append(events, single)
+	}
+	for _, payload := range events {
+		if payload.EventType == eventType && payload.AgreementID == agreement_id {
+			if payload.TxID != c.stub.txID || payload.EventVersion != EventVersion {
+				t.Errorf("got event payload %+v", payload)
+			}
+			return payload
+		}
+	}
+	t.Fatalf("no %s event for %s in %s", eventType, agreement_id, ev.Payload)
+	return AgreementEvent{}
+}
+
+func TestInit(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "admin resets", caller: "admin", function: "init", args: []string{"again"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				if got := string(c.stub.state["abc"]); got != "again" {
+					t.Errorf("abc = %q", got)
+				}
+			}},
+		{name: "borrower is not admin", caller: "alice", function: "init", args: []string{"again"}, code: ErrForbidden},
+		{name: "admin role from another MSP", caller: "mallory", function: "init", args: []string{"again"}, code: ErrForbidden},
+		{name: "no arguments", caller: "admin", function: "init", code: ErrInvalidArgument},
+	}, false)
+}
+
+func TestRoleMSPs(t *testing.T) {
+	foreign := func(c *testChain) { c.ids["servicer3"] = newIdentity(c.t, "Org3MSP", "servicer3", "servicer") }
+	runCases(t, []chainCase{
+		{name: "servicer from a foreign MSP records no repayment", setup: foreign, caller: "servicer3", function: "record_repayment",
+			args: []string{"A3", "100", "2026-02-15", "Alice", "r1"}, code: ErrForbidden},
+		{name: "servicer from a foreign MSP changes no party", setup: foreign, caller: "servicer3", function: "update_party",
+			args: []string{"Alice", `{"kyc_status":"suspended"}`}, code: ErrForbidden},
+		{name: "servicer role no MSP grants", caller: "servicer", function: "update_party", args: []string{"Alice", `{"kyc_status":"suspended"}`},
+			setup: func(c *testChain) { c.mustInvoke("admin", "set_role_msps", "servicer", "") },
+			code:  ErrForbidden},
+		{name: "bind another MSP", caller: "servicer3", function: "record_repayment", args: []string{"A3", "100", "2026-02-15", "Alice", "r1"},
+			setup: func(c *testChain) {
+				foreign(c)
+				c.mustInvoke("admin", "set_role_msps", "servicer", "Org2MSP, Org3MSP")
+			}},
+		{name: "admin needs an MSP", caller: "admin", function: "set_role_msps", args: []string{"admin", " "}, code: ErrInvalidArgument},
+		{name: "unknown role", caller: "admin", function: "set_role_msps", args: []string{"banker", "Org1MSP"}, code: ErrInvalidArgument},
+		{name: "servicers cannot", caller: "servicer", function: "set_role_msps", args: []string{"servicer", "Org3MSP"}, code: ErrForbidden},
+	}, false)
+	t.Run("legacy init binds admin to the deploying MSP", func(t *testing.T) {
+		c := newTestChain(t)
+		c.mustInvoke("admin", "init", "hello")
+		if got := string(c.stub.state[AdminMSPsKey]); got != `{"schema_version":3,"msps":["Org1MSP"]}` {
+			t.Errorf("admin MSPs = %s", got)
+		}
+		if _, err := c.invoke("mallory", "init", "again"); errorCode(err) != ErrForbidden {
+			t.Errorf("got %v", err)
+		}
+	})
+	t.Run("privileged roles are refused until configured", func(t *testing.T) {
+		c := newTestChain(t)
+		c.mustInvoke("admin", "init", "hello", "Org1MSP")
+		if _, err := c.invoke("servicer", "register_party", "Dave", "Dave Lee", "individual", "Org1MSP", "dave", contactHash); errorCode(err) != ErrForbidden {
+			t.Errorf("got %v", err)
+		}
+		c.mustInvoke("admin", "register_party", "Dave", "Dave Lee", "individual", "Org1MSP", "dave", contactHash)
+	})
+}
+
+func TestCreateAgreement(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "draft", caller: "alice", function: "create_agreement", args: terms("B1", "", "2027-01-15", "new"),
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				res := c.agreement("B1")
+				if currentStatus(res) != StatusDraft || res.Version != 1 || res.LoanAmount.MinorUnits != 1000000 {
+					t.Errorf("got %+v", res)
+				}
+				ev := expectEvent(t, c, EventAgreementCreated, "B1")
+				if ev.NewStatus != StatusDraft || ev.Actor != "Org1MSP/alice" {
+					t.Errorf("got event %+v", ev)
+				}
+			}},
+		{name: "bullet repayment", caller: "bob", function: "create_agreement",
+			args: append(terms("B1", "proposed", "2027-01-15", ""), "Bullet"),
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				if res := c.agreement("B1"); res.RepaymentType != string(RepaymentBullet) || currentStatus(res) != StatusProposed {
+					t.Errorf("got %+v", res)
+				}
+			}},
+		{name: "JSON form", caller: "alice", function: "create_agreement",
+			args: []string{`{"agreement_id":"B1","borrower_id":"Alice","lender_id":"Bob","loan_amount":"250.50 EUR","interest_rate":"3.5","loan_duration":"2 years"}`},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var response Response
+				if err := json.Unmarshal(payload, &response); err != nil || response.Status != "ok" {
+					t.Errorf("got %s", payload)
+				}
+				if res := c.agreement("B1"); res.LoanAmount.Currency != "EUR" || res.LoanDuration.Unit != "year" {
+					t.Errorf("got %+v", res)
+				}
+			}},
+		{name: "JSON form missing a required argument", caller: "alice", function: "create_agreement",
+			args: []string{`{"agreement_id":"B1","borrower_id":"Alice","lender_id":"Bob"}`}, code: ErrInvalidArgument},
+		{name: "duplicate id", caller: "alice", function: "create_agreement", args: terms("A1", "", "", ""), code: ErrAlreadyExists},
+		{name: "not a party", caller: "carol", function: "create_agreement", args: terms("B1", "", "", ""), code: ErrForbidden},
+		{name: "bad amount", caller: "alice", function: "create_agreement",
+			args: []string{"B1", "Alice", "Bob", "", "ten thousand", "", "12", "12", "", "", "", ""}, code: ErrInvalidArgument},
+		{name: "bad repayment date", caller: "alice", function: "create_agreement", args: terms("B1", "", "15/01/2027", ""), code: ErrInvalidArgument},
+		{name: "term too long", caller: "alice", function: "create_agreement",
+			args: []string{"B1", "Alice", "Bob", "", "10000", "", "12", "1000000000 years", "", "", "", ""}, code: ErrInvalidArgument},
+		{name: "longest term", caller: "alice", function: "create_agreement",
+			args: []string{"B1", "Alice", "Bob", "", "10000", "", "12", "50 years", "", "", "", ""}},
+		{name: "cannot start active", caller: "alice", function: "create_agreement", args: terms("B1", "active", "", ""), code: ErrInvalidArgument},
+		{name: "too few arguments", caller: "alice", function: "create_agreement", args: []string{"B1", "Alice", "Bob"}, code: ErrInvalidArgument},
+	}, false)
+}
+
+func TestUpdatePO(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "comments come from the comments argument", caller: "alice", function: "update_po",
+			args: []string{"A1", "Alice", "Bob", "2026-02-01", "12000 USD", "draft", "10", "24", "2028-02-01", "true", "true", "revised"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				res := c.agreement("A1")
+				if res.Comments != "revised" || res.LoanAmount.MinorUnits != 1200000 || res.Version != 2 {
+					t.Errorf("got %+v", res)
+				}
+				if res.BorrowerSignature != nil || res.BorrowerSigned == "true" {
+					t.Errorf("signed flags must be ignored, got %+v", res)
+				}
+				expectEvent(t, c, EventAgreementUpdated, "A1")
+			}},
+		{name: "propose", caller: "bob", function: "update_po", args: terms("A1", "proposed", "", ""),
+			check: expectStatus("A1", StatusProposed)},
+		{name: "expected version", caller: "alice", function: "update_po", args: append(terms("A1", "draft", "", "v"), "1")},
+		{name: "stale version", caller: "alice", function: "update_po", args: append(terms("A1", "draft", "", "v"), "7"), code: ErrConflict},
+		{name: "unknown id", caller: "alice", function: "update_po", args: terms("X9", "draft", "", ""), code: ErrNotFound},
+		{name: "terms out for signing", caller: "alice", function: "update_po", args: terms("A2", "proposed", "", "changed"), code: ErrInvalidTransition},
+		{name: "cancel while out for signing", caller: "alice", function: "update_po", args: terms("A2", "cancelled", "", ""),
+			check: expectStatus("A2", StatusCancelled)},
+		{name: "not a party", caller: "carol", function: "update_po", args: terms("A1", "draft", "", ""), code: ErrForbidden},
+		{name: "too few arguments", caller: "alice", function: "update_po", args: []string{"A1"}, code: ErrInvalidArgument},
+	}, false)
+}
+
+func TestUpdateAgreement(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "locked fields while draft", caller: "alice", function: "update_agreement",
+			args: []string{"A1", `{"loan_amount":"15000 USD","comments":"bigger"}`},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				if res := c.agreement("A1"); res.LoanAmount.MinorUnits != 1500000 || res.Comments != "bigger" {
+					t.Errorf("got %+v", res)
+				}
+				expectEvent(t, c, EventAgreementUpdated, "A1")
+			}},
+		{name: "null clears a field", caller: "alice", function: "update_agreement", args: []string{"A1", `{"repayment_date":null}`},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				if res := c.agreement("A1"); res.RepaymentDate != "" {
+					t.Errorf("got %+v", res)
+				}
+			}},
+		{name: "locked once active", caller: "bob", function: "update_agreement", args: []string{"A3", `{"interest_rate":"1"}`}, code: ErrInvalidArgument},
+		{name: "nothing changes while awaiting signatures", caller: "alice", function: "update_agreement", args: []string{"A2", `{"comments":"sign this instead"}`},
+			code: ErrInvalidTransition},
+		{name: "maturity locked once active", caller: "alice", function: "update_agreement", args: []string{"A3", `{"repayment_date":null}`}, code: ErrInvalidArgument},
+		{name: "unknown field", caller: "alice", function: "update_agreement", args: []string{"A1", `{"colour":"red"}`}, code: ErrInvalidArgument},
+		{name: "stale version", caller: "alice", function: "update_agreement", args: []string{"A1", `{"comments":"x"}`, "2"}, code: ErrConflict},
+		{name: "unknown id", caller: "alice", function: "update_agreement", args: []string{"X9", `{"comments":"x"}`}, code: ErrNotFound},
+		{name: "patching the caller out", caller: "alice", function: "update_agreement", args: []string{"A1", `{"borrower_id":"Carol"}`}, code: ErrForbidden},
+	}, false)
+}
+
+func TestUpdateStatus(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "propose", caller: "bob", function: "update_status", args: []string{"A1", "Proposed"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				ev := expectEvent(t, c, EventStatusChanged, "A1")
+				if ev.OldStatus != StatusDraft || ev.NewStatus != StatusProposed {
+					t.Errorf("got event %+v", ev)
+				}
+			}},
+		{name: "servicer defaults an active loan", caller: "servicer", function: "update_status", args: []string{"A3", "defaulted"},
+			check: expectStatus("A3", StatusDefaulted)},
+		{name: "signing statuses need signatures", caller: "bob", function: "update_status", args: []string{"A2", "signed"}, code: ErrInvalidTransition},
+		{name: "not allowed by the lifecycle", caller: "bob", function: "update_status", args: []string{"A1", "defaulted"}, code: ErrInvalidTransition},
+		{name: "repaid only through repayments", caller: "bob", function: "update_status", args: []string{"A3", "repaid"}, code: ErrInvalidTransition},
+		{name: "unknown status", caller: "bob", function: "update_status", args: []string{"A1", "pending"}, code: ErrInvalidArgument},
+		{name: "borrowers cannot", caller: "alice", function: "update_status", args: []string{"A1", "proposed"}, code: ErrForbidden},
+	}, false)
+}
+
+func TestDeletePO(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "archive a draft", caller: "servicer", function: "delete_po", args: []string{"A1", "duplicate"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				res := c.agreement("A1")
+				if res.Archived == nil || res.Archived.Reason != "duplicate" || currentStatus(res) != StatusCancelled {
+					t.Errorf("got %+v", res)
+				}
+				expectEvent(t, c, EventAgreementArchived, "A1")
+			}},
+		{name: "active loans stay", caller: "admin", function: "delete_po", args: []string{"A3", "closing"}, code: ErrInvalidTransition},
+		{name: "already archived", caller: "servicer", function: "delete_po", args: []string{"A1", "again"}, code: ErrInvalidTransition,
+			setup: func(c *testChain) { c.mustInvoke("servicer", "delete_po", "A1", "duplicate") }},
+		{name: "reason required", caller: "servicer", function: "delete_po", args: []string{"A1", " "}, code: ErrInvalidArgument},
+		{name: "unknown id", caller: "servicer", function: "delete_po", args: []string{"X9", "gone"}, code: ErrNotFound},
+		{name: "parties cannot", caller: "alice", function: "delete_po", args: []string{"A1", "mine"}, code: ErrForbidden},
+	}, false)
+}
+
+func TestRestoreAndPurge(t *testing.T) {
+	archiveA1 := func(c *testChain) { c.mustInvoke("servicer", "delete_po", "A1", "duplicate") }
+	runCases(t, []chainCase{
+		{name: "restore", setup: archiveA1, caller: "servicer", function: "restore_agreement", args: []string{"A1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				if res := c.agreement("A1"); res.Archived != nil {
+					t.Errorf("got %+v", res)
+				}
+				expectEvent(t, c, EventAgreementRestored, "A1")
+			}},
+		{name: "restore a live agreement", caller: "servicer", function: "restore_agreement", args: []string{"A1"}, code: ErrInvalidTransition},
+		{name: "purge a draft", setup: archiveA1, caller: "admin", function: "purge_agreement", args: []string{"A1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				if _, ok := c.stub.state["A1"]; ok {
+					t.Error("A1 is still stored")
+				}
+				for key := range c.stub.state {
+					if strings.Contains(key, "\x00A1\x00") {
+						t.Errorf("index entry %q left behind", key)
+					}
+				}
+				expectEvent(t, c, EventAgreementDeleted, "A1")
+			}},
+		{name: "purge a signed loan", caller: "admin", function: "purge_agreement", args: []string{"A3"}, code: ErrInvalidTransition},
+		{name: "servicers cannot purge", setup: archiveA1, caller: "servicer", function: "purge_agreement", args: []string{"A1"}, code: ErrForbidden},
+	}, false)
+}
+
+func TestSign(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "borrower signs", caller: "alice", function: "borrower_sign", args: []string{"A2"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				res := c.agreement("A2")
+				if res.BorrowerSignature == nil || res.BorrowerSignature.TxID != c.stub.txID || currentStatus(res) != StatusProposed {
+					t.Errorf("got %+v", res)
+				}
+				expectEvent(t, c, EventAgreementSigned, "A2")
+			}},
+		{name: "second signature activates", setup: func(c *testChain) { c.mustInvoke("alice", "borrower_sign", "A2") },
+			caller: "bob", function: "lender_sign", args: []string{"A2"}, check: expectStatus("A2", StatusActive)},
+		{name: "signed twice", setup: func(c *testChain) { c.mustInvoke("alice", "borrower_sign", "A2") },
+			caller: "alice", function: "borrower_sign", args: []string{"A2"}, code: ErrAlreadyExists},
+		{name: "wrong party", caller: "bob", function: "borrower_sign", args: []string{"A2"}, code: ErrForbidden},
+		{name: "legacy record without a borrower", caller: "stranger", function: "borrower_sign", args: []string{"L2"},
+			setup: func(c *testChain) {
+				c.ids["stranger"] = newIdentity(c.t, "Org3MSP", "stranger", "borrower")
+				c.stub.begin(nil)
+				c.stub.PutState("L2", []byte(`{"agreement_id":"L2","borrower_name":"","lender_name":"Bob","agreement_date":"2016-05-01","loan_amount":"5000","agreement_status":"proposed","interest_rate":"7.5","loan_duration":"24","repayment_date":"2018-05-01","borrower_signed":"","lender_signed":"","comments":""}`))
+				c.stub.commit()
+			},
+			code: ErrForbidden},
+		{name: "drafts are not out for signing", caller: "alice", function: "borrower_sign", args: []string{"A1"}, code: ErrInvalidTransition},
+		{name: "stale version", caller: "bob", function: "lender_sign", args: []string{"A2", "5"}, code: ErrConflict},
+	}, false)
+}
+
+func TestRecordRepayment(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "partial", caller: "bob", function: "record_repayment", args: []string{"A3", "1000", "2026-02-15", "Alice", "r1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				res := c.agreement("A3")
+				if res.OutstandingBalance == nil || res.OutstandingBalance.MinorUnits != 900000 || currentStatus(res) != StatusActive {
+					t.Errorf("got %+v", res)
+				}
+				expectEvent(t, c, EventRepaymentRecorded, "A3")
+			}},
+		{name: "in full", caller: "servicer", function: "record_repayment", args: []string{"A3", "10000 USD", "2026-02-15", "Alice", "r1"},
+			check: expectStatus("A3", StatusRepaid)},
+		{name: "interest before principal", setup: at("2026-07-15"), caller: "bob", function: "record_repayment", args: []string{"A3", "1000", "2026-07-15", "Alice", "r1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				res := c.agreement("A3")
+				if res.AccruedInterest.MinorUnits != 0 || res.OutstandingBalance.MinorUnits != 1000000-40493 {
+					t.Errorf("got %+v", res)
+				}
+				var repayments []Repayment
+				payload, _ = c.query("bob", "getRepayments_byAgreement", "A3")
+				if err := json.Unmarshal(payload, &repayments); err != nil || len(repayments) != 1 || repayments[0].InterestPaid.MinorUnits != 59507 || repayments[0].PenaltyPaid.MinorUnits != 0 {
+					t.Errorf("got %s", payload)
+				}
+			}},
+		{name: "principal repaid, interest still owed", setup: at("2026-07-15"), caller: "bob", function: "record_repayment", args: []string{"A3", "10000", "2026-07-15", "Alice", "r1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectStatus("A3", StatusActive)(t, c, payload)
+				c.mustInvoke("bob", "record_repayment", "A3", "595.07", "2026-07-15", "Alice", "r2")
+				expectStatus("A3", StatusRepaid)(t, c, payload)
+			}},
+		{name: "more than owed with interest", setup: at("2026-07-15"), caller: "bob", function: "record_repayment", args: []string{"A3", "10595.08", "2026-07-15", "", ""},
+			code: ErrInvalidArgument},
+		{name: "more than owed", caller: "bob", function: "record_repayment", args: []string{"A3", "10000.01", "2026-02-15", "", ""}, code: ErrInvalidArgument},
+		{name: "other currency", caller: "bob", function: "record_repayment", args: []string{"A3", "10 EUR", "2026-02-15", "", ""}, code: ErrInvalidArgument},
+		{name: "loan not started", caller: "bob", function: "record_repayment", args: []string{"A1", "10", "2026-02-15", "", ""}, code: ErrInvalidTransition},
+		{name: "borrowers cannot", caller: "alice", function: "record_repayment", args: []string{"A3", "10", "2026-02-15", "", ""}, code: ErrForbidden},
+	}, false)
+}
+
+func TestMigrateIndex(t *testing.T) {
+	legacy := func(c *testChain) {
+		c.stub.begin(nil)
+		c.stub.PutState("L1", []byte(`{"agreement_id":"L1","borrower_name":"Alice","lender_name":"Bob","agreement_date":"2016-05-01","loan_amount":"5000","agreement_status":"Active","interest_rate":"7.5","loan_duration":"24","repayment_date":"2018-05-01","borrower_signed":"true","lender_signed":"true","comments":""}`))
+		c.stub.PutState(LoanIndexStr, []byte(`["L1","GONE"]`))
+		c.stub.commit()
+	}
+	runCases(t, []chainCase{
+		{name: "all at once", setup: legacy, caller: "admin", function: "migrate_index",
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				if string(payload) != `{"migrated":1,"remaining":0,"skipped":1}` {
+					t.Errorf("got %s", payload)
+				}
+				if _, ok := c.stub.state[LoanIndexStr]; ok {
+					t.Error("legacy index left behind")
+				}
+				if res := c.agreement("L1"); res.LoanAmount.MinorUnits != 500000 || currentStatus(res) != StatusActive || res.BorrowerID != "Alice" || res.LenderID != "Bob" || res.Version != 1 {
+					t.Errorf("got %+v", res)
+				}
+				for _, index := range [][]string{{BorrowerIndex, "Alice"}, {LenderIndex, "Bob"}} {
+					key, _ := c.stub.CreateCompositeKey(index[0], []string{index[1], "L1"})
+					if _, ok := c.stub.state[key]; !ok {
+						t.Errorf("L1 missing from %s under %s", index[0], index[1])
+					}
+				}
+			}},
+		{name: "in batches", setup: legacy, caller: "admin", function: "migrate_index", args: []string{"1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				if got := string(c.stub.state[LoanIndexStr]); got != `["GONE"]` {
+					t.Errorf("legacy index is %s", got)
+				}
+			}},
+		{name: "the legacy version is stale", caller: "servicer", function: "update_status", args: []string{"L1", "defaulted", "0"},
+			setup: func(c *testChain) {
+				legacy(c)
+				c.mustInvoke("admin", "migrate_index")
+			},
+			code: ErrConflict},
+		{name: "bad batch size", setup: legacy, caller: "admin", function: "migrate_index", args: []string{"0"}, code: ErrInvalidArgument},
+		{name: "admins only", setup: legacy, caller: "servicer", function: "migrate_index", code: ErrForbidden},
+	}, false)
+}
+
+func TestUnknownFunction(t *testing.T) {
+	c := seededChain(t)
+	for _, who := range []string{"admin", "carol"} {
+		if _, err := c.invoke(who, "transfer", "A1"); errorCode(err) != ErrInvalidArgument {
+			t.Errorf("invoke as %s: got %v", who, err)
+		}
+		if _, err := c.query(who, "getAgreement_byOwner", "A1"); errorCode(err) != ErrInvalidArgument {
+			t.Errorf("query as %s: got %v", who, err)
+		}
+	}
+}
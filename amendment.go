@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// AmendmentObjectType - composite key prefix of amendments, keyed by agreement id and amendment id
+const AmendmentObjectType = "amendment"
+
+// AmendmentStatus - where an amendment stands
+type AmendmentStatus string
+
+const (
+	AmendmentPending   AmendmentStatus = "pending"   // waiting for the counter-party
+	AmendmentApproved  AmendmentStatus = "approved"  // applied to the Agreement
+	AmendmentRejected  AmendmentStatus = "rejected"  // turned down by the counter-party
+	AmendmentWithdrawn AmendmentStatus = "withdrawn" // taken back by the proposer
+)
+
+type Amendment struct { // A change to the terms of a signed Agreement, stored under the Agreement
+	Document
+	AgreementID    string            `json:"agreement_id"`
+	AmendmentID    string            `json:"amendment_id"` // tx id of the proposal
+	Changes        map[string]string `json:"changes"`      // new values keyed by JSON field name
+	Previous       map[string]string `json:"previous,omitempty"`
+	Reason         string            `json:"reason"`
+	Status         AmendmentStatus   `json:"status"`
+	ProposedBy     string            `json:"proposed_by"` // party id of the proposer
+	ProposedAt     string            `json:"proposed_at"`
+	DecidedBy      string            `json:"decided_by,omitempty"`
+	DecidedAt      string            `json:"decided_at,omitempty"`
+	DecisionReason string            `json:"decision_reason,omitempty"`
+	Version        int64             `json:"agreement_version"` // version of the Agreement when it was proposed
+}
+
+var amendmentMigrations = map[int]migration{} //amendments were added at schema version 3
+
+// amendableFields - terms a signed Agreement may change by amendment, with their current value as text
+var amendableFields = map[string]func(res *Agreement) string{
+	"interest_rate":   func(r *Agreement) string { return r.InterestRate.String() },
+	"loan_duration":   func(r *Agreement) string { return r.LoanDuration.String() },
+	"repayment_type":  func(r *Agreement) string { return r.RepaymentType },
+	"day_count":       func(r *Agreement) string { return r.DayCount },
+	"interest_method": func(r *Agreement) string { return r.InterestMethod },
+	"repayment_date":  func(r *Agreement) string { return r.RepaymentDate },
+}
+
+// ============================================================================================================================
+// shiftMaturity - a repayment date moved by a change of the loan duration, so it stays as far from the start of the loan
+// ============================================================================================================================
+func shiftMaturity(date string, from, to Duration) (string, error) {
+	maturity, err := time.Parse(DateLayout, date)
+	if err != nil {
+		return "", invalidField("repayment_date", date, "expecting YYYY-MM-DD")
+	}
+	fromMonths, monthly := from.Months()
+	toMonths, ok := to.Months()
+	if monthly && ok {
+		return addMonths(maturity, toMonths-fromMonths).Format(DateLayout), nil
+	}
+	return durationAfter(durationAfter(maturity, from, -1), to, 1).Format(DateLayout), nil
+}
+
+// durationAfter - the date a duration after t, before it when sign is -1
+func durationAfter(t time.Time, d Duration, sign int) time.Time {
+	if months, ok := d.Months(); ok {
+		return addMonths(t, sign*months)
+	}
+	days := d.Value
+	if d.Unit == "week" {
+		days *= 7
+	}
+	return t.AddDate(0, 0, sign*days)
+}
+
+// ============================================================================================================================
+// amendable - the terms of an Agreement change by amendment once both parties signed and until the loan ends
+// ============================================================================================================================
+func amendable(res *Agreement) bool {
+	status := currentStatus(res)
+	return (signingStatuses[status] || outstandingStatuses[status]) && res.Archived == nil
+}
+
+// ============================================================================================================================
+// partySide - the party id a caller acts for on an Agreement, empty when staff or not a party
+// ============================================================================================================================
+func partySide(caller Caller, res *Agreement) string {
+	if caller.IsPartyTo(res) {
+		return caller.Party
+	}
+	return ""
+}
+
+// ============================================================================================================================
+// amendmentKey / getAmendment / putAmendment - read and write an amendment under its Agreement
+// ============================================================================================================================
+func amendmentKey(stub shim.ChaincodeStubInterface, agreement_id, amendment_id string) (string, error) {
+	if amendment_id == "" {
+		return "", invalidField("amendment_id", amendment_id, "must not be empty")
+	}
+	return stub.CreateCompositeKey(AmendmentObjectType, []string{agreement_id, amendment_id})
+}
+
+func getAmendment(stub shim.ChaincodeStubInterface, agreement_id, amendment_id string) (*Amendment, error) {
+	key, err := amendmentKey(stub, agreement_id, amendment_id)
+	if err != nil {
+		return nil, err
+	}
+	amAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state for amendment " + amendment_id)
+	}
+	if amAsBytes == nil {
+		return nil, notFound("amendment_id", amendment_id, "Amendment "+amendment_id+" not found on Agreement "+agreement_id)
+	}
+	am := Amendment{}
+	if err = decodeDocument(amAsBytes, amendmentMigrations, &am); err != nil {
+		return nil, fmt.Errorf("Failed to decode amendment %s: %s", amendment_id, err)
+	}
+	return &am, nil
+}
+
+func putAmendment(stub shim.ChaincodeStubInterface, am *Amendment) error {
+	key, err := amendmentKey(stub, am.AgreementID, am.AmendmentID)
+	if err != nil {
+		return err
+	}
+	return putDocument(stub, key, am)
+}
+
+// ============================================================================================================================
+// applyAmendment - apply the changes of an amendment to an Agreement, keeping the values they replace
+// ============================================================================================================================
+func applyAmendment(res *Agreement, am *Amendment) error {
+	am.Previous = map[string]string{}
+	for name, value := range am.Changes {
+		current, ok := amendableFields[name]
+		if !ok {
+			return invalidField(name, value, "not a term amendments may change")
+		}
+		am.Previous[name] = current(res)
+		if err := patchFields[name].apply(res, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// propose_amendment - propose changes to the terms of a signed Agreement, applied once the counter-party approves
+// ============================================================================================================================
+func (t *ManageLoan) propose_amendment(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id, a JSON object of the changes, reason and an optional expected version")
+	}
+	fmt.Println("start propose_amendment")
+	agreement_id := args[0]
+	var changes map[string]*string
+	if err := json.Unmarshal([]byte(args[1]), &changes); err != nil {
+		return nil, invalidField("changes", args[1], "expecting a JSON object of the terms to change")
+	}
+	if len(changes) == 0 {
+		return nil, invalidField("changes", args[1], "no terms to change")
+	}
+	reason := strings.TrimSpace(args[2])
+	if reason == "" {
+		return nil, invalidField("reason", args[2], "must not be empty")
+	}
+	res, err := getAgreement(stub, agreement_id)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := getCallerWithRoles(stub)
+	if err != nil {
+		return nil, err
+	}
+	party := partySide(caller, res)
+	if party == "" {
+		return nil, forbidden("Access denied: %s is not a party to Agreement %s", caller.Party, agreement_id)
+	}
+	if err = checkVersion(res, optionalArg(args, 3)); err != nil {
+		return nil, err
+	}
+	if !amendable(res) {
+		return nil, invalidTransition("agreement_status", "Agreement %s is %s, only signed loans are amended, drafts change through update_agreement", agreement_id, currentStatus(res))
+	}
+	if res.PendingAmendment != "" {
+		return nil, invalidTransition("pending_amendment", "Agreement %s already has amendment %s pending", agreement_id, res.PendingAmendment)
+	}
+
+	amended := *res //validate on a copy, nothing changes until the counter-party approves
+	am := Amendment{AgreementID: agreement_id, AmendmentID: stub.GetTxID(), Changes: map[string]string{}, Reason: reason, Status: AmendmentPending, ProposedBy: party, Version: res.Version}
+	names := make([]string, 0, len(changes))
+	for name := range changes {
+		names = append(names, name)
+	}
+	sort.Strings(names) //report the same error whatever order the client sent
+	for _, name := range names {
+		value := changes[name]
+		current, ok := amendableFields[name]
+		if !ok {
+			return nil, invalidField(name, args[1], "not a term amendments may change, expecting interest_rate, loan_duration, repayment_type, day_count, interest_method or repayment_date")
+		}
+		if value == nil {
+			return nil, invalidField(name, args[1], "expecting a string")
+		}
+		if err = patchFields[name].apply(&amended, *value); err != nil {
+			return nil, err
+		}
+		if current(&amended) == current(res) {
+			return nil, invalidField(name, *value, "same as the current term")
+		}
+		am.Changes[name] = current(&amended) //stored in canonical form
+	}
+	_, lengthened := am.Changes["loan_duration"]
+	if _, moved := am.Changes["repayment_date"]; lengthened && !moved && res.RepaymentDate != "" && res.LoanDuration.Valid() {
+		date, err := shiftMaturity(res.RepaymentDate, res.LoanDuration, amended.LoanDuration) //the maturity follows the term
+		if err != nil {
+			return nil, err
+		}
+		am.Changes["repayment_date"] = date
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	am.ProposedAt = now.Format(time.RFC3339)
+	if err = putAmendment(stub, &am); err != nil {
+		return nil, err
+	}
+	res.PendingAmendment = am.AmendmentID
+	if err = putAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	status := currentStatus(res)
+	if err = emitEvent(stub, EventAmendmentProposed, agreement_id, status, status); err != nil {
+		return nil, err
+	}
+	fmt.Println("end propose_amendment")
+	return json.Marshal(am)
+}
+
+// ============================================================================================================================
+// approve_amendment - the counter-party accepts the pending amendment and its changes are applied
+// ============================================================================================================================
+func (t *ManageLoan) approve_amendment(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return t.decide_amendment(stub, args, true)
+}
+
+// ============================================================================================================================
+// reject_amendment - the counter-party turns the pending amendment down, or the proposer withdraws it
+// ============================================================================================================================
+func (t *ManageLoan) reject_amendment(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return t.decide_amendment(stub, args, false)
+}
+
+// ============================================================================================================================
+// decide_amendment - close the pending amendment of an Agreement, applying it when approved
+// ============================================================================================================================
+func (t *ManageLoan) decide_amendment(stub shim.ChaincodeStubInterface, args []string, approve bool) ([]byte, error) {
+	if approve && len(args) != 2 && len(args) != 3 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id, amendment_id and an optional expected version")
+	}
+	if !approve && len(args) != 3 && len(args) != 4 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id, amendment_id, reason and an optional expected version")
+	}
+	fmt.Println("start decide_amendment")
+	agreement_id, amendment_id := args[0], args[1]
+	versionArg, reason := 2, ""
+	if !approve {
+		versionArg, reason = 3, strings.TrimSpace(args[2])
+		if reason == "" {
+			return nil, invalidField("reason", args[2], "must not be empty")
+		}
+	}
+	res, err := getAgreement(stub, agreement_id)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := getCallerWithRoles(stub)
+	if err != nil {
+		return nil, err
+	}
+	party := partySide(caller, res)
+	if party == "" {
+		return nil, forbidden("Access denied: %s is not a party to Agreement %s", caller.Party, agreement_id)
+	}
+	if err = checkVersion(res, optionalArg(args, versionArg)); err != nil {
+		return nil, err
+	}
+	am, err := getAmendment(stub, agreement_id, amendment_id)
+	if err != nil {
+		return nil, err
+	}
+	if am.Status != AmendmentPending {
+		return nil, invalidTransition("amendment_id", "Amendment %s is already %s", amendment_id, am.Status)
+	}
+	if party == am.ProposedBy && approve {
+		return nil, forbidden("Access denied: %s proposed amendment %s, the counter-party approves it", party, amendment_id)
+	}
+
+	status := currentStatus(res)
+	event := EventAmendmentRejected
+	switch {
+	case approve:
+		if !amendable(res) {
+			return nil, invalidTransition("agreement_status", "Agreement %s is %s and can no longer be amended", agreement_id, status)
+		}
+		if outstandingStatuses[status] { //interest up to today runs on the terms being replaced
+			if _, err = accrue(stub, res); err != nil {
+				return nil, err
+			}
+		}
+		if err = applyAmendment(res, am); err != nil {
+			return nil, err
+		}
+		am.Status, event = AmendmentApproved, EventAmendmentApproved
+	case party == am.ProposedBy:
+		am.Status = AmendmentWithdrawn
+	default:
+		am.Status = AmendmentRejected
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	am.DecidedBy, am.DecidedAt, am.DecisionReason = party, now.Format(time.RFC3339), reason
+	if err = putAmendment(stub, am); err != nil {
+		return nil, err
+	}
+	res.PendingAmendment = ""
+	if err = putAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	if err = emitEvent(stub, event, agreement_id, status, currentStatus(res)); err != nil {
+		return nil, err
+	}
+	fmt.Println("end decide_amendment")
+	return json.Marshal(am)
+}
+
+// ============================================================================================================================
+// getAmendments_byAgreement - every amendment proposed on an Agreement and its outcome, oldest first
+// ============================================================================================================================
+func (t *ManageLoan) getAmendments_byAgreement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id")
+	}
+	fmt.Println("start getAmendments_byAgreement")
+	res, err := getAgreement(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if err = authorizeAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	iter, err := stub.GetStateByPartialCompositeKey(AmendmentObjectType, []string{args[0]})
+	if err != nil {
+		return nil, errors.New("Failed to get amendments for " + args[0])
+	}
+	defer iter.Close()
+	amendments := []Amendment{}
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		var am Amendment
+		if err = decodeDocument(kv.Value, amendmentMigrations, &am); err != nil {
+			return nil, errors.New("Failed to decode amendment " + kv.Key)
+		}
+		amendments = append(amendments, am)
+	}
+	sort.SliceStable(amendments, func(i, j int) bool { return amendments[i].Version < amendments[j].Version }) //keys sort by tx id
+	fmt.Println("end getAmendments_byAgreement")
+	return json.Marshal(amendments)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// propose - Alice proposes a lower rate on A3, returning the amendment id
+func propose(c *testChain) string {
+	c.t.Helper()
+	var am Amendment
+	if err := json.Unmarshal(c.mustInvoke("alice", "propose_amendment", "A3", `{"interest_rate":"10"}`, "refinancing"), &am); err != nil {
+		c.t.Fatal(err)
+	}
+	return am.AmendmentID
+}
+
+func TestProposeAmendment(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "propose", caller: "alice", function: "propose_amendment", args: []string{"A3", `{"loan_duration":"18","interest_rate":"10.5%"}`, "longer and cheaper"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var am Amendment
+				if err := json.Unmarshal(payload, &am); err != nil {
+					t.Fatalf("got %s", payload)
+				}
+				if am.Status != AmendmentPending || am.ProposedBy != "Alice" || am.Changes["interest_rate"] != "10.50%" || am.Changes["loan_duration"] != "18 month" {
+					t.Errorf("got %+v", am)
+				}
+				if am.Changes["repayment_date"] != "2027-09-15" {
+					t.Errorf("the maturity moves with the term, got %+v", am)
+				}
+				res := c.agreement("A3")
+				if res.PendingAmendment != am.AmendmentID || res.InterestRate.BasisPoints != 1200 {
+					t.Errorf("applied before approval, got %+v", res)
+				}
+				expectEvent(t, c, EventAmendmentProposed, "A3")
+			}},
+		{name: "one pending at a time", setup: func(c *testChain) { propose(c) }, caller: "bob", function: "propose_amendment",
+			args: []string{"A3", `{"loan_duration":"24"}`, "extension"}, code: ErrInvalidTransition},
+		{name: "drafts change through update_agreement", caller: "alice", function: "propose_amendment", args: []string{"A1", `{"interest_rate":"10"}`, "cheaper"},
+			code: ErrInvalidTransition},
+		{name: "not an amendable term", caller: "alice", function: "propose_amendment", args: []string{"A3", `{"loan_amount":"20000"}`, "more"},
+			code: ErrInvalidArgument},
+		{name: "no change", caller: "alice", function: "propose_amendment", args: []string{"A3", `{"interest_rate":"12.00"}`, "same"}, code: ErrInvalidArgument},
+		{name: "bad value", caller: "alice", function: "propose_amendment", args: []string{"A3", `{"interest_rate":"cheap"}`, "cheaper"}, code: ErrInvalidArgument},
+		{name: "no reason", caller: "alice", function: "propose_amendment", args: []string{"A3", `{"interest_rate":"10"}`, " "}, code: ErrInvalidArgument},
+		{name: "stale version", caller: "alice", function: "propose_amendment", args: []string{"A3", `{"interest_rate":"10"}`, "cheaper", "1"}, code: ErrConflict},
+		{name: "not a party", caller: "carol", function: "propose_amendment", args: []string{"A3", `{"interest_rate":"10"}`, "cheaper"}, code: ErrForbidden},
+		{name: "staff cannot", caller: "servicer", function: "propose_amendment", args: []string{"A3", `{"interest_rate":"10"}`, "cheaper"}, code: ErrForbidden},
+		{name: "update_po keeps signed terms", caller: "bob", function: "update_po", args: []string{"A3", "Alice", "Bob", "2026-01-15", "10000 USD", "active", "10", "12", "", "", "", ""},
+			code: ErrInvalidArgument},
+		{name: "update_po keeps the maturity", caller: "bob", function: "update_po", args: []string{"A3", "Alice", "Bob", "2026-01-15", "10000 USD", "active", "12", "12", "2027-06-15", "", "", ""},
+			code: ErrInvalidArgument},
+		{name: "a shorter term with its own maturity", caller: "bob", function: "propose_amendment", args: []string{"A3", `{"loan_duration":"6","repayment_date":"2026-08-01"}`, "early"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var am Amendment
+				if err := json.Unmarshal(payload, &am); err != nil || am.Changes["repayment_date"] != "2026-08-01" {
+					t.Errorf("got %s", payload)
+				}
+			}},
+		{name: "update_po still changes comments", caller: "bob", function: "update_po", args: []string{"A3", "Alice", "Bob", "2026-01-15", "10000.00 USD", "active", "12%", "12 months", "2027-03-15", "", "", "noted"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				if res := c.agreement("A3"); res.Comments != "noted" {
+					t.Errorf("got %+v", res)
+				}
+			}},
+	}, false)
+}
+
+func TestDecideAmendment(t *testing.T) {
+	t.Run("approve", func(t *testing.T) {
+		c := seededChain(t)
+		at("2026-07-15")(c)
+		id := propose(c)
+		c.mustInvoke("bob", "approve_amendment", "A3", id)
+		res := c.agreement("A3")
+		if res.InterestRate.BasisPoints != 1000 || res.PendingAmendment != "" {
+			t.Errorf("got %+v", res)
+		}
+		if res.AccruedThrough != "2026-07-15" || res.AccruedInterest.MinorUnits != 59507 {
+			t.Errorf("interest before the amendment accrues at the old rate, got %+v", res)
+		}
+		am, err := getAmendment(c.stub, "A3", id)
+		if err != nil || am.Status != AmendmentApproved || am.DecidedBy != "Bob" || am.Previous["interest_rate"] != "12.00%" {
+			t.Errorf("got %+v, %v", am, err)
+		}
+		expectEvent(t, c, EventAmendmentApproved, "A3")
+	})
+	t.Run("extension moves the maturity", func(t *testing.T) {
+		c := seededChain(t)
+		var am Amendment
+		json.Unmarshal(c.mustInvoke("bob", "propose_amendment", "A3", `{"loan_duration":"2 years"}`, "extension"), &am)
+		c.mustInvoke("alice", "approve_amendment", "A3", am.AmendmentID)
+		if res := c.agreement("A3"); res.LoanDuration.String() != "2 year" || res.RepaymentDate != "2028-03-15" {
+			t.Errorf("got %+v", res)
+		}
+		if am, _ := getAmendment(c.stub, "A3", am.AmendmentID); am.Previous["repayment_date"] != "2027-03-15" {
+			t.Errorf("got %+v", am)
+		}
+	})
+	t.Run("reject", func(t *testing.T) {
+		c := seededChain(t)
+		id := propose(c)
+		c.mustInvoke("bob", "reject_amendment", "A3", id, "rate is fixed")
+		if am, _ := getAmendment(c.stub, "A3", id); am.Status != AmendmentRejected || am.DecisionReason != "rate is fixed" {
+			t.Errorf("got %+v", am)
+		}
+		if res := c.agreement("A3"); res.InterestRate.BasisPoints != 1200 || res.PendingAmendment != "" {
+			t.Errorf("got %+v", res)
+		}
+		if _, err := c.invoke("bob", "approve_amendment", "A3", id); errorCode(err) != ErrInvalidTransition {
+			t.Errorf("approved a rejected amendment: %v", err)
+		}
+		propose(c) //the next one may be proposed
+	})
+	t.Run("withdraw", func(t *testing.T) {
+		c := seededChain(t)
+		id := propose(c)
+		c.mustInvoke("alice", "reject_amendment", "A3", id, "changed my mind")
+		if am, _ := getAmendment(c.stub, "A3", id); am.Status != AmendmentWithdrawn || am.DecidedBy != "Alice" {
+			t.Errorf("got %+v", am)
+		}
+		expectEvent(t, c, EventAmendmentRejected, "A3")
+	})
+	t.Run("errors", func(t *testing.T) {
+		c := seededChain(t)
+		id := propose(c)
+		for _, tc := range []struct {
+			caller   string
+			function string
+			args     []string
+			code     ErrorCode
+		}{
+			{"alice", "approve_amendment", []string{"A3", id}, ErrForbidden}, //the proposer cannot approve
+			{"carol", "approve_amendment", []string{"A3", id}, ErrForbidden},
+			{"servicer", "approve_amendment", []string{"A3", id}, ErrForbidden},
+			{"bob", "approve_amendment", []string{"A3", "tx999"}, ErrNotFound},
+			{"bob", "approve_amendment", []string{"A2", id}, ErrNotFound},
+			{"bob", "approve_amendment", []string{"A3", id, "1"}, ErrConflict},
+			{"bob", "reject_amendment", []string{"A3", id}, ErrInvalidArgument},
+		} {
+			if _, err := c.invoke(tc.caller, tc.function, tc.args...); errorCode(err) != tc.code {
+				t.Errorf("%s %s %v: got %v, want %s", tc.caller, tc.function, tc.args, err, tc.code)
+			}
+		}
+	})
+	t.Run("loan ended meanwhile", func(t *testing.T) {
+		c := seededChain(t)
+		id := propose(c)
+		c.mustInvoke("bob", "record_repayment", "A3", "10000", "2026-02-15", "Alice", "r1")
+		if _, err := c.invoke("bob", "approve_amendment", "A3", id); errorCode(err) != ErrInvalidTransition {
+			t.Errorf("got %v", err)
+		}
+		c.mustInvoke("bob", "reject_amendment", "A3", id, "loan repaid")
+	})
+}
+
+func TestGetAmendments(t *testing.T) {
+	history := func(c *testChain) {
+		c.mustInvoke("alice", "reject_amendment", "A3", propose(c), "typo")
+		c.mustInvoke("bob", "approve_amendment", "A3", propose(c))
+		c.mustInvoke("bob", "propose_amendment", "A3", `{"loan_duration":"24"}`, "extension")
+	}
+	runCases(t, []chainCase{
+		{name: "every amendment, oldest first", setup: history, caller: "alice", function: "getAmendments_byAgreement", args: []string{"A3"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var amendments []Amendment
+				if err := json.Unmarshal(payload, &amendments); err != nil || len(amendments) != 3 {
+					t.Fatalf("got %s", payload)
+				}
+				for i, status := range []AmendmentStatus{AmendmentWithdrawn, AmendmentApproved, AmendmentPending} {
+					if amendments[i].Status != status {
+						t.Errorf("amendment %d is %s, want %s", i, amendments[i].Status, status)
+					}
+				}
+			}},
+		{name: "none", caller: "auditor", function: "getAmendments_byAgreement", args: []string{"A1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				if string(payload) != "[]" {
+					t.Errorf("got %s", payload)
+				}
+			}},
+		{name: "not a party", setup: history, caller: "carol", function: "getAmendments_byAgreement", args: []string{"A3"}, code: ErrForbidden},
+	}, true)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Function kinds, a function is either invoked or queried
+const (
+	KindInvoke = "invoke"
+	KindQuery  = "query"
+)
+
+// Parameter types of the JSON argument form, formats say how a string is read
+const (
+	TypeString  = "string"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+	TypeObject  = "object"
+)
+
+type Param struct { // A named argument, listed in positional order
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Format      string `json:"format,omitempty"` // money, rate, duration, date, status, repayment_type, day_count, interest_method, csv
+	Required    bool   `json:"required"`
+	Description string `json:"description,omitempty"`
+}
+
+type FunctionSchema struct { // Arguments of an invoke or query function
+	Function string  `json:"function"`
+	Kind     string  `json:"kind"`
+	Params   []Param `json:"params"`
+	fixed    int     // positional arguments always sent, the rest are trailing optionals
+}
+
+type Response struct { // Envelope returned to clients using the JSON argument form
+	Status string          `json:"status"` // ok or error
+	Data   json.RawMessage `json:"data"`
+	Error  *ChaincodeError `json:"error,omitempty"`
+}
+
+var (
+	paramAgreementID     = Param{Name: "agreement_id", Type: TypeString, Required: true}
+	paramAmendmentID     = Param{Name: "amendment_id", Type: TypeString, Required: true, Description: "tx id of the proposal"}
+	paramPartyID         = Param{Name: "party_id", Type: TypeString, Required: true}
+	paramCollateralID    = Param{Name: "collateral_id", Type: TypeString, Required: true}
+	paramExpectedVersion = Param{Name: "expected_version", Type: TypeInteger, Description: "fail with CONFLICT unless the Agreement is at this version"}
+	paramPageSize        = Param{Name: "page_size", Type: TypeInteger, Description: "records per page, defaults to 100, at most 1000"}
+	paramBookmark        = Param{Name: "bookmark", Type: TypeString, Description: "bookmark of the previous page"}
+	paramIncludeArchived = Param{Name: "include_archived", Type: TypeBoolean, Description: "list archived Agreements too"}
+	agreementTerms       = []Param{
+		paramAgreementID,
+		{Name: "borrower_id", Type: TypeString, Required: true, Description: "registered and KYC approved party, see register_party"},
+		{Name: "lender_id", Type: TypeString, Required: true, Description: "registered and KYC approved party, see register_party"},
+		{Name: "agreement_date", Type: TypeString},
+		{Name: "loan_amount", Type: TypeString, Format: "money", Required: true, Description: "amount with an optional ISO 4217 currency, e.g. 10000.50 USD"},
+		{Name: "agreement_status", Type: TypeString, Format: "status"},
+		{Name: "interest_rate", Type: TypeString, Format: "rate", Required: true, Description: "annual percentage, e.g. 7.25"},
+		{Name: "loan_duration", Type: TypeString, Format: "duration", Required: true, Description: "count with an optional unit, months when left out"},
+		{Name: "repayment_date", Type: TypeString, Format: "date"},
+		{Name: "borrower_signed", Type: TypeString, Description: "ignored, see borrower_sign"},
+		{Name: "lender_signed", Type: TypeString, Description: "ignored, see lender_sign"},
+		{Name: "comments", Type: TypeString},
+	}
+)
+
+// apiSchema - the published argument schema of every function, returned by get_schema
+var apiSchema = []FunctionSchema{
+	{Function: "init", Kind: KindInvoke, fixed: 1, Params: []Param{
+		{Name: "message", Type: TypeString, Required: true},
+		{Name: "admin_msps", Type: TypeString, Format: "csv", Description: "MSP IDs allowed to grant the admin role, the caller's MSP on a first init without it"},
+	}},
+	{Function: "set_role_msps", Kind: KindInvoke, fixed: 2, Params: []Param{
+		{Name: "role", Type: TypeString, Required: true, Description: "borrower, lender, servicer, auditor or admin"},
+		{Name: "msps", Type: TypeString, Format: "csv", Required: true, Description: "MSP IDs allowed to grant the role, empty refuses servicers and auditors"},
+	}},
+	{Function: "create_agreement", Kind: KindInvoke, fixed: 12, Params: append(append([]Param{}, agreementTerms...),
+		Param{Name: "repayment_type", Type: TypeString, Format: "repayment_type"},
+	)},
+	{Function: "update_po", Kind: KindInvoke, fixed: 12, Params: append(append([]Param{}, agreementTerms...),
+		paramExpectedVersion,
+	)},
+	{Function: "update_agreement", Kind: KindInvoke, fixed: 2, Params: []Param{
+		paramAgreementID,
+		{Name: "patch", Type: TypeObject, Required: true, Description: "only the fields to change, null clears a field"},
+		paramExpectedVersion,
+	}},
+	{Function: "update_status", Kind: KindInvoke, fixed: 2, Params: []Param{
+		paramAgreementID,
+		{Name: "status", Type: TypeString, Format: "status", Required: true},
+		paramExpectedVersion,
+	}},
+	{Function: "delete_po", Kind: KindInvoke, fixed: 2, Params: []Param{
+		paramAgreementID,
+		{Name: "reason", Type: TypeString, Required: true},
+		paramExpectedVersion,
+	}},
+	{Function: "restore_agreement", Kind: KindInvoke, fixed: 1, Params: []Param{paramAgreementID, paramExpectedVersion}},
+	{Function: "purge_agreement", Kind: KindInvoke, fixed: 1, Params: []Param{paramAgreementID, paramExpectedVersion}},
+	{Function: "borrower_sign", Kind: KindInvoke, fixed: 1, Params: []Param{paramAgreementID, paramExpectedVersion}},
+	{Function: "lender_sign", Kind: KindInvoke, fixed: 1, Params: []Param{paramAgreementID, paramExpectedVersion}},
+	{Function: "record_repayment", Kind: KindInvoke, fixed: 5, Params: []Param{
+		paramAgreementID,
+		{Name: "amount", Type: TypeString, Format: "money", Required: true},
+		{Name: "date", Type: TypeString, Format: "date", Required: true},
+		{Name: "payer", Type: TypeString},
+		{Name: "reference", Type: TypeString},
+		paramExpectedVersion,
+	}},
+	{Function: "accrue_interest", Kind: KindInvoke, fixed: 1, Params: []Param{paramAgreementID, paramExpectedVersion}},
+	{Function: "propose_amendment", Kind: KindInvoke, fixed: 3, Params: []Param{
+		paramAgreementID,
+		{Name: "changes", Type: TypeObject, Required: true, Description: "new values of interest_rate, loan_duration, repayment_type, day_count, interest_method or repayment_date, a new loan_duration moves repayment_date with it"},
+		{Name: "reason", Type: TypeString, Required: true},
+		paramExpectedVersion,
+	}},
+	{Function: "approve_amendment", Kind: KindInvoke, fixed: 2, Params: []Param{paramAgreementID, paramAmendmentID, paramExpectedVersion}},
+	{Function: "reject_amendment", Kind: KindInvoke, fixed: 3, Params: []Param{
+		paramAgreementID, paramAmendmentID,
+		{Name: "reason", Type: TypeString, Required: true},
+		paramExpectedVersion,
+	}},
+	{Function: "register_party", Kind: KindInvoke, fixed: 6, Params: []Param{
+		paramPartyID,
+		{Name: "legal_name", Type: TypeString, Required: true},
+		{Name: "type", Type: TypeString, Required: true, Description: "individual or organization"},
+		{Name: "msp_id", Type: TypeString, Required: true, Description: "MSP of the identity acting for the party"},
+		{Name: "identity", Type: TypeString, Required: true, Description: "common name of the certificate acting for the party"},
+		{Name: "contact_hash", Type: TypeString, Required: true, Description: "hex SHA-256 of the contact details"},
+	}},
+	{Function: "update_party", Kind: KindInvoke, fixed: 2, Params: []Param{
+		paramPartyID,
+		{Name: "patch", Type: TypeObject, Required: true, Description: "only the fields to change, KYC reviews set kyc_status"},
+	}},
+	{Function: "register_collateral", Kind: KindInvoke, fixed: 6, Params: []Param{
+		paramCollateralID,
+		{Name: "type", Type: TypeString, Required: true, Description: "e.g. real_estate, vehicle, securities"},
+		{Name: "description", Type: TypeString},
+		{Name: "appraised_value", Type: TypeString, Format: "money", Required: true},
+		{Name: "valuation_date", Type: TypeString, Format: "date", Required: true},
+		{Name: "owner", Type: TypeString, Required: true, Description: "party owning the asset"},
+	}},
+	{Function: "pledge_collateral", Kind: KindInvoke, fixed: 2, Params: []Param{paramAgreementID, paramCollateralID, paramExpectedVersion}},
+	{Function: "release_collateral", Kind: KindInvoke, fixed: 2, Params: []Param{paramAgreementID, paramCollateralID, paramExpectedVersion}},
+	{Function: "check_overdue", Kind: KindInvoke, Params: []Param{}},
+	{Function: "set_overdue_policy", Kind: KindInvoke, fixed: 3, Params: []Param{
+		{Name: "penalty_rate", Type: TypeString, Format: "rate", Required: true, Description: "annual rate on the outstanding balance from the repayment date"},
+		{Name: "grace_days", Type: TypeInteger, Required: true, Description: "days after the repayment date before a loan is overdue"},
+		{Name: "default_after_days", Type: TypeInteger, Required: true, Description: "days overdue before a loan is defaulted, 0 never defaults"},
+	}},
+	{Function: "migrate_index", Kind: KindInvoke, Params: []Param{
+		{Name: "batch_size", Type: TypeInteger, Description: "Agreements to move, all when left out"},
+	}},
+
+	{Function: "getAgreement_byID", Kind: KindQuery, fixed: 1, Params: []Param{paramAgreementID}},
+	{Function: "getAgreement_byBuyer", Kind: KindQuery, fixed: 1, Params: []Param{
+		{Name: "lender_id", Type: TypeString, Required: true},
+		paramPageSize, paramBookmark, paramIncludeArchived,
+	}},
+	{Function: "getAgreement_bySeller", Kind: KindQuery, fixed: 1, Params: []Param{
+		{Name: "borrower_id", Type: TypeString, Required: true},
+		paramPageSize, paramBookmark, paramIncludeArchived,
+	}},
+	{Function: "get_AllAgreement", Kind: KindQuery, Params: []Param{paramPageSize, paramBookmark, paramIncludeArchived}},
+	{Function: "getRepayments_byAgreement", Kind: KindQuery, fixed: 1, Params: []Param{paramAgreementID}},
+	{Function: "getSchedule", Kind: KindQuery, fixed: 1, Params: []Param{paramAgreementID}},
+	{Function: "getAccruedInterest", Kind: KindQuery, fixed: 1, Params: []Param{
+		paramAgreementID,
+		{Name: "as_of_date", Type: TypeString, Format: "date", Description: "the transaction date when left out"},
+	}},
+	{Function: "getAmendments_byAgreement", Kind: KindQuery, fixed: 1, Params: []Param{paramAgreementID}},
+	{Function: "getParty_byID", Kind: KindQuery, fixed: 1, Params: []Param{paramPartyID}},
+	{Function: "getCollateral_byID", Kind: KindQuery, fixed: 1, Params: []Param{paramCollateralID}},
+	{Function: "getLoanToValue", Kind: KindQuery, fixed: 1, Params: []Param{paramAgreementID}},
+	{Function: "getOverduePolicy", Kind: KindQuery, Params: []Param{}},
+	{Function: "getAgreement_byStatus", Kind: KindQuery, fixed: 1, Params: []Param{
+		{Name: "status", Type: TypeString, Format: "status", Required: true},
+		paramPageSize, paramBookmark, paramIncludeArchived,
+	}},
+	{Function: "getAgreement_byRepaymentDate", Kind: KindQuery, fixed: 2, Params: []Param{
+		{Name: "from", Type: TypeString, Format: "date", Required: true},
+		{Name: "to", Type: TypeString, Format: "date", Required: true},
+		paramPageSize, paramBookmark, paramIncludeArchived,
+	}},
+	{Function: "query_agreements", Kind: KindQuery, fixed: 1, Params: []Param{
+		{Name: "query", Type: TypeObject, Required: true, Description: "filter and sort, see AgreementQuery"},
+		paramPageSize, paramBookmark, paramIncludeArchived,
+	}},
+	{Function: "getAgreement_history", Kind: KindQuery, fixed: 1, Params: []Param{
+		paramAgreementID,
+		{Name: "diff", Type: TypeBoolean, Description: "list the fields each version changed"},
+	}},
+	{Function: "get_schema", Kind: KindQuery, Params: []Param{
+		{Name: "function", Type: TypeString, Description: "only this function, every function when left out"},
+	}},
+}
+
+// ============================================================================================================================
+// findSchema - the schema of an invoke or query function
+// ============================================================================================================================
+func findSchema(kind, function string) (FunctionSchema, bool) {
+	for _, schema := range apiSchema {
+		if schema.Kind == kind && schema.Function == function {
+			return schema, true
+		}
+	}
+	return FunctionSchema{}, false
+}
+
+// ============================================================================================================================
+// decodeArguments - turn a single JSON object of named arguments into positional ones, other calls pass through untouched.
+// A lone argument is the JSON form only when it is an object naming nothing but parameters of the function, so the
+// positional query_agreements filter still reads as before.
+// ============================================================================================================================
+func decodeArguments(kind, function string, args []string) ([]string, bool, error) {
+	schema, ok := findSchema(kind, function)
+	if !ok || len(args) != 1 || !strings.HasPrefix(strings.TrimSpace(args[0]), "{") {
+		return args, false, nil
+	}
+	var named map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(args[0]), &named); err != nil {
+		return args, false, nil
+	}
+	index := map[string]int{}
+	required := false
+	for i, param := range schema.Params {
+		index[param.Name] = i
+		required = required || param.Required
+	}
+	if len(named) == 0 && required {
+		return args, false, nil
+	}
+	for name := range named {
+		if _, ok := index[name]; !ok {
+			return args, false, nil
+		}
+	}
+
+	positional := make([]string, len(schema.Params))
+	last := schema.fixed
+	for i, param := range schema.Params {
+		raw, ok := named[param.Name]
+		if !ok || bytes.Equal(raw, []byte("null")) {
+			if param.Required {
+				return nil, true, invalidField(param.Name, "", "required")
+			}
+			continue
+		}
+		value, err := decodeParam(param, raw)
+		if err != nil {
+			return nil, true, err
+		}
+		positional[i] = value
+		if i+1 > last {
+			last = i + 1
+		}
+	}
+	return positional[:last], true, nil
+}
+
+// ============================================================================================================================
+// decodeParam - check a named argument has the type its parameter declares and give it in positional form
+// ============================================================================================================================
+func decodeParam(param Param, raw json.RawMessage) (string, error) {
+	switch param.Type {
+	case TypeString:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return "", invalidField(param.Name, string(raw), "expecting a string")
+		}
+		return s, nil
+	case TypeInteger:
+		var n int64
+		if err := json.Unmarshal(raw, &n); err != nil {
+			return "", invalidField(param.Name, string(raw), "expecting a whole number")
+		}
+		return fmt.Sprint(n), nil
+	case TypeBoolean:
+		var b bool
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return "", invalidField(param.Name, string(raw), "expecting true or false")
+		}
+		return fmt.Sprint(b), nil
+	case TypeObject:
+		var o map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &o); err != nil {
+			return "", invalidField(param.Name, string(raw), "expecting a JSON object")
+		}
+		return string(raw), nil
+	}
+	return "", fmt.Errorf("Parameter %s has an unknown type %s", param.Name, param.Type)
+}
+
+// ============================================================================================================================
+// respond - wrap the result of a JSON form call in a Response, a failure still fails the transaction
+// ============================================================================================================================
+func respond(payload []byte, err error) ([]byte, error) {
+	if err != nil {
+		response := Response{Status: "error", Data: json.RawMessage("null"), Error: asChaincodeError(err)}
+		jsonAsBytes, _ := json.Marshal(response)
+		return nil, errors.New(string(jsonAsBytes))
+	}
+	response := Response{Status: "ok", Data: json.RawMessage("null")}
+	if len(payload) > 0 {
+		if json.Valid(payload) {
+			response.Data = payload
+		} else {
+			response.Data, _ = json.Marshal(string(payload))
+		}
+	}
+	return json.Marshal(response)
+}
+
+// ============================================================================================================================
+// get_schema - the published argument schema, of one function or of all
+// ============================================================================================================================
+func (t *ManageLoan) get_schema(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting an optional function name")
+	}
+	if len(args) == 0 || args[0] == "" {
+		return json.Marshal(apiSchema)
+	}
+	schemas := []FunctionSchema{}
+	for _, schema := range apiSchema {
+		if schema.Function == args[0] {
+			schemas = append(schemas, schema)
+		}
+	}
+	if len(schemas) == 0 {
+		return nil, invalidField("function", args[0], "unknown function")
+	}
+	return json.Marshal(schemas)
+}
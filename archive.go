@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+type Archive struct { // Why, when and by whom an Agreement was taken off the book
+	Reason    string `json:"reason"`
+	Actor     string `json:"actor"`
+	Timestamp string `json:"timestamp"`
+	TxID      string `json:"tx_id"`
+}
+
+// ============================================================================================================================
+// archiveAgreement - flag an Agreement as archived by the caller
+// ============================================================================================================================
+func archiveAgreement(stub shim.ChaincodeStubInterface, res *Agreement, reason string) error {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	res.Archived = &Archive{
+		Reason:    reason,
+		Actor:     caller.String(),
+		Timestamp: now.Format(time.RFC3339),
+		TxID:      stub.GetTxID(),
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// restore_agreement - bring an archived Agreement back into the listings, its status is left as it was
+// ============================================================================================================================
+func (t *ManageLoan) restore_agreement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id and an optional expected version")
+	}
+	fmt.Println("start restore_agreement")
+	agreement_id := args[0]
+	res, err := getAgreement(stub, agreement_id)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkVersion(res, optionalArg(args, 1)); err != nil {
+		return nil, err
+	}
+	if res.Archived == nil {
+		return nil, invalidTransition("archived", "Agreement %s is not archived", agreement_id)
+	}
+	res.Archived = nil
+	if err = putAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	status := currentStatus(res)
+	if err = emitEvent(stub, EventAgreementRestored, agreement_id, status, status); err != nil {
+		return nil, err
+	}
+	fmt.Println("end restore_agreement")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// purge_agreement - delete a draft that was never proposed or signed, every other Agreement can only be archived
+// ============================================================================================================================
+func (t *ManageLoan) purge_agreement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id and an optional expected version")
+	}
+	fmt.Println("start purge_agreement")
+	agreement_id := args[0]
+	res, err := getAgreement(stub, agreement_id)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkVersion(res, optionalArg(args, 1)); err != nil {
+		return nil, err
+	}
+	if !neverSigned(res) {
+		return nil, invalidTransition("agreement_status", "Agreement %s has been out for signing, archive it with delete_po instead", agreement_id)
+	}
+	if err = deleteAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	if err = emitEvent(stub, EventAgreementDeleted, agreement_id, currentStatus(res), ""); err != nil {
+		return nil, err
+	}
+	fmt.Println("end purge_agreement")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// neverSigned - the Agreement only ever was a draft, possibly cancelled since, and carries no signature
+// ============================================================================================================================
+func neverSigned(res *Agreement) bool {
+	if res.BorrowerSignature != nil || res.LenderSignature != nil || res.BorrowerSigned == "true" || res.LenderSigned == "true" {
+		return false
+	}
+	for _, change := range res.StatusHistory {
+		if change.To != StatusDraft && change.To != StatusCancelled {
+			return false
+		}
+	}
+	status := currentStatus(res)
+	return status == StatusDraft || status == StatusCancelled
+}
+
+// ============================================================================================================================
+// unarchived - drop archived Agreements from a listing unless they were asked for
+// ============================================================================================================================
+func unarchived(records []*Agreement, includeArchived bool) []*Agreement {
+	if includeArchived {
+		return records
+	}
+	kept := []*Agreement{}
+	for _, res := range records {
+		if res.Archived == nil {
+			kept = append(kept, res)
+		}
+	}
+	return kept
+}
+
+// ============================================================================================================================
+// parseIncludeArchived - read the include archived flag of a listing
+// ============================================================================================================================
+func parseIncludeArchived(s string) (bool, error) {
+	if s == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(s)
+	if err != nil {
+		return false, invalidField("include_archived", s, "expecting true or false")
+	}
+	return include, nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// CollateralObjectType - composite key prefix of collateral assets, keyed by collateral id
+const CollateralObjectType = "collateral"
+
+type Collateral struct { // An asset securing one Agreement at a time
+	Document
+	CollateralID   string `json:"collateral_id"`
+	Type           string `json:"type"`
+	Description    string `json:"description"`
+	AppraisedValue Money  `json:"appraised_value"`
+	ValuationDate  string `json:"valuation_date"`
+	Owner          string `json:"owner"`
+	PledgedTo      string `json:"pledged_to,omitempty"` // agreement id, empty while the asset is free
+	PledgedAt      string `json:"pledged_at,omitempty"`
+}
+
+type LoanToValue struct { // Outstanding balance of an Agreement against the value of its collateral
+	AgreementID     string        `json:"agreement_id"`
+	Balance         Money         `json:"balance"`
+	CollateralValue Money         `json:"collateral_value"`
+	LTV             *Rate         `json:"ltv"` // null without collateral
+	Collateral      []*Collateral `json:"collateral"`
+}
+
+// ============================================================================================================================
+// collateralKey - state key of a collateral asset
+// ============================================================================================================================
+func collateralKey(stub shim.ChaincodeStubInterface, collateral_id string) (string, error) {
+	if collateral_id == "" {
+		return "", invalidField("collateral_id", collateral_id, "must not be empty")
+	}
+	return stub.CreateCompositeKey(CollateralObjectType, []string{collateral_id})
+}
+
+// ============================================================================================================================
+// getCollateral - read a collateral asset, error when it does not exist
+// ============================================================================================================================
+func getCollateral(stub shim.ChaincodeStubInterface, collateral_id string) (*Collateral, error) {
+	key, err := collateralKey(stub, collateral_id)
+	if err != nil {
+		return nil, err
+	}
+	colAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state for collateral " + collateral_id)
+	}
+	if colAsBytes == nil {
+		return nil, notFound("collateral_id", collateral_id, "Collateral not found: "+collateral_id)
+	}
+	col := Collateral{}
+	if err = decodeDocument(colAsBytes, collateralMigrations, &col); err != nil {
+		return nil, fmt.Errorf("Failed to decode collateral %s: %s", collateral_id, err)
+	}
+	return &col, nil
+}
+
+// ============================================================================================================================
+// putCollateral - store a collateral asset under its composite key
+// ============================================================================================================================
+func putCollateral(stub shim.ChaincodeStubInterface, col *Collateral) error {
+	key, err := collateralKey(stub, col.CollateralID)
+	if err != nil {
+		return err
+	}
+	return putDocument(stub, key, col)
+}
+
+// ============================================================================================================================
+// livePledge - the Agreement a collateral asset secures, nil when it is free or its loan has ended
+// ============================================================================================================================
+func livePledge(stub shim.ChaincodeStubInterface, col *Collateral) (*Agreement, error) {
+	if col.PledgedTo == "" {
+		return nil, nil
+	}
+	res, err := getAgreement(stub, col.PledgedTo)
+	if err != nil {
+		if asChaincodeError(err).Code == ErrNotFound { //purged since
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(allowedTransitions[currentStatus(res)]) == 0 { //repaid or cancelled
+		return nil, nil
+	}
+	return res, nil
+}
+
+// ============================================================================================================================
+// releaseAll - free every collateral asset pledged to an Agreement, the caller stores the Agreement
+// ============================================================================================================================
+func releaseAll(stub shim.ChaincodeStubInterface, res *Agreement) error {
+	for _, collateral_id := range res.Collateral {
+		col, err := getCollateral(stub, collateral_id)
+		if err != nil {
+			return err
+		}
+		if col.PledgedTo != res.AgreeementID {
+			continue
+		}
+		col.PledgedTo, col.PledgedAt = "", ""
+		if err = putCollateral(stub, col); err != nil {
+			return err
+		}
+		if err = emitCollateralEvent(stub, EventCollateralReleased, res, collateral_id); err != nil {
+			return err
+		}
+	}
+	res.Collateral = nil
+	return nil
+}
+
+// ============================================================================================================================
+// register_collateral - record a collateral asset with its appraised value, borrowers and lenders only register their own
+// ============================================================================================================================
+func (t *ManageLoan) register_collateral(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 6 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting collateral_id, type, description, appraised_value, valuation_date and owner")
+	}
+	fmt.Println("start register_collateral")
+	collateral_id := args[0]
+	key, err := collateralKey(stub, collateral_id)
+	if err != nil {
+		return nil, err
+	}
+	col_type := strings.TrimSpace(args[1])
+	if col_type == "" {
+		return nil, invalidField("type", args[1], "must not be empty")
+	}
+	value, err := ParseMoney("appraised_value", args[3])
+	if err != nil {
+		return nil, err
+	}
+	if _, err = time.Parse(DateLayout, args[4]); err != nil {
+		return nil, invalidField("valuation_date", args[4], "expecting YYYY-MM-DD")
+	}
+	owner := args[5]
+	if owner == "" {
+		return nil, invalidField("owner", owner, "must not be empty")
+	}
+	caller, err := getCallerWithRoles(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !caller.SeesAll() && caller.Party != owner {
+		return nil, forbidden("Access denied: %s cannot register collateral owned by %s", caller.Party, owner)
+	}
+	colAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state for collateral " + collateral_id)
+	}
+	if colAsBytes != nil {
+		return nil, alreadyExists("collateral_id", collateral_id, "This collateral already exists")
+	}
+	col := Collateral{
+		CollateralID:   collateral_id,
+		Type:           col_type,
+		Description:    args[2],
+		AppraisedValue: value,
+		ValuationDate:  args[4],
+		Owner:          owner,
+	}
+	if err = putCollateral(stub, &col); err != nil {
+		return nil, err
+	}
+	fmt.Println("end register_collateral")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// pledge_collateral - secure an Agreement with a collateral asset that no other live Agreement holds
+// ============================================================================================================================
+func (t *ManageLoan) pledge_collateral(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id, collateral_id and an optional expected version")
+	}
+	fmt.Println("start pledge_collateral")
+	agreement_id, collateral_id := args[0], args[1]
+	res, err := getAgreement(stub, agreement_id)
+	if err != nil {
+		return nil, err
+	}
+	if err = authorizeAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	if err = checkVersion(res, optionalArg(args, 2)); err != nil {
+		return nil, err
+	}
+	status := currentStatus(res)
+	if len(allowedTransitions[status]) == 0 {
+		return nil, invalidTransition("agreement_status", "Agreement %s is %s and takes no more collateral", agreement_id, status)
+	}
+	if awaitingSignatures(res) { //the terms out for signing are the deal, pledge in draft or once the loan runs
+		return nil, invalidTransition("agreement_status", "Agreement %s is awaiting signatures and can only be cancelled", agreement_id)
+	}
+	col, err := getCollateral(stub, collateral_id)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := getCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+	if col.Owner != res.BorrowerID && (caller.Party == "" || col.Owner != caller.Party) { //nobody pledges someone else's asset
+		return nil, forbidden("Access denied: collateral %s belongs to %s, neither the borrower of Agreement %s nor the caller", collateral_id, col.Owner, agreement_id)
+	}
+	holder, err := livePledge(stub, col)
+	if err != nil {
+		return nil, err
+	}
+	if holder != nil {
+		return nil, invalidTransition("collateral_id", "Collateral %s is already pledged to Agreement %s", collateral_id, holder.AgreeementID)
+	}
+	if col.AppraisedValue.Currency != res.LoanAmount.Currency {
+		return nil, invalidField("collateral_id", collateral_id, "appraised in "+col.AppraisedValue.Currency+", the loan is in "+res.LoanAmount.Currency)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	col.PledgedTo = agreement_id
+	col.PledgedAt = now.Format(time.RFC3339)
+	if err = putCollateral(stub, col); err != nil {
+		return nil, err
+	}
+	res.Collateral = append(res.Collateral, collateral_id)
+	if err = putAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	if err = emitCollateralEvent(stub, EventCollateralPledged, res, collateral_id); err != nil {
+		return nil, err
+	}
+	fmt.Println("end pledge_collateral")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// release_collateral - free a collateral asset from the Agreement it secures
+// ============================================================================================================================
+func (t *ManageLoan) release_collateral(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id, collateral_id and an optional expected version")
+	}
+	fmt.Println("start release_collateral")
+	agreement_id, collateral_id := args[0], args[1]
+	res, err := getAgreement(stub, agreement_id)
+	if err != nil {
+		return nil, err
+	}
+	if err = authorizeAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	if err = checkVersion(res, optionalArg(args, 2)); err != nil {
+		return nil, err
+	}
+	col, err := getCollateral(stub, collateral_id)
+	if err != nil {
+		return nil, err
+	}
+	pledged := -1
+	for i, id := range res.Collateral {
+		if id == collateral_id {
+			pledged = i
+		}
+	}
+	if pledged < 0 || col.PledgedTo != agreement_id {
+		return nil, invalidTransition("collateral_id", "Collateral %s is not pledged to Agreement %s", collateral_id, agreement_id)
+	}
+	col.PledgedTo, col.PledgedAt = "", ""
+	if err = putCollateral(stub, col); err != nil {
+		return nil, err
+	}
+	res.Collateral = append(res.Collateral[:pledged], res.Collateral[pledged+1:]...)
+	if err = putAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	if err = emitCollateralEvent(stub, EventCollateralReleased, res, collateral_id); err != nil {
+		return nil, err
+	}
+	fmt.Println("end release_collateral")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// getCollateral_byID - a collateral asset, seen by its owner, the parties to the Agreement it secures and staff
+// ============================================================================================================================
+func (t *ManageLoan) getCollateral_byID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting collateral_id")
+	}
+	fmt.Println("start getCollateral_byID")
+	col, err := getCollateral(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	caller, err := getCallerWithRoles(stub)
+	if err != nil {
+		return nil, err
+	}
+	if !caller.SeesAll() && caller.Party != col.Owner {
+		if col.PledgedTo == "" {
+			return nil, forbidden("Access denied: %s does not own collateral %s", caller.Party, col.CollateralID)
+		}
+		res, err := getAgreement(stub, col.PledgedTo)
+		if err != nil {
+			return nil, err
+		}
+		if err = authorizeAgreement(stub, res); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Println("end getCollateral_byID")
+	return json.Marshal(col)
+}
+
+// ============================================================================================================================
+// getLoanToValue - the outstanding balance of an Agreement as a percentage of the appraised value of its collateral
+// ============================================================================================================================
+func (t *ManageLoan) getLoanToValue(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id")
+	}
+	fmt.Println("start getLoanToValue")
+	res, err := getAgreement(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if err = authorizeAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	balance, err := outstandingBalance(res)
+	if err != nil {
+		return nil, err
+	}
+	ltv := LoanToValue{AgreementID: res.AgreeementID, Balance: balance, CollateralValue: balance.Amount(0), Collateral: []*Collateral{}}
+	for _, collateral_id := range res.Collateral {
+		col, err := getCollateral(stub, collateral_id)
+		if err != nil {
+			return nil, err
+		}
+		ltv.CollateralValue.MinorUnits += col.AppraisedValue.MinorUnits
+		ltv.Collateral = append(ltv.Collateral, col)
+	}
+	if ltv.CollateralValue.MinorUnits > 0 {
+		ratio := new(big.Int).Mul(big.NewInt(balance.MinorUnits), big.NewInt(10000))
+		ltv.LTV = &Rate{BasisPoints: roundCents(new(big.Rat).SetFrac(ratio, big.NewInt(ltv.CollateralValue.MinorUnits)))}
+	}
+	fmt.Println("end getLoanToValue")
+	return json.Marshal(ltv)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// collateral - register a house of Alice's appraised at 20000 USD
+func collateral(c *testChain) {
+	c.mustInvoke("alice", "register_collateral", "C1", "real_estate", "house", "20000 USD", "2026-01-10", "Alice")
+}
+
+// pledged - C1 secures the active A3
+func pledged(c *testChain) {
+	collateral(c)
+	c.mustInvoke("alice", "pledge_collateral", "A3", "C1")
+}
+
+// expectPledge - check which Agreement a collateral asset secures, empty when it is free
+func expectPledge(collateral_id, agreement_id string) func(t *testing.T, c *testChain, payload []byte) {
+	return func(t *testing.T, c *testChain, payload []byte) {
+		col, err := getCollateral(c.stub, collateral_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if col.PledgedTo != agreement_id {
+			t.Errorf("got %+v", col)
+		}
+	}
+}
+
+func TestCollateral(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "register", caller: "alice", function: "register_collateral", args: []string{"C1", "vehicle", "", "8000.50 USD", "2026-01-10", "Alice"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				col, err := getCollateral(c.stub, "C1")
+				if err != nil || col.Type != "vehicle" || col.AppraisedValue.MinorUnits != 800050 || col.SchemaVersion != SchemaVersion {
+					t.Errorf("got %+v, %v", col, err)
+				}
+			}},
+		{name: "register for someone else", caller: "alice", function: "register_collateral", args: []string{"C1", "vehicle", "", "8000 USD", "2026-01-10", "Bob"},
+			code: ErrForbidden},
+		{name: "servicer registers for a borrower", caller: "servicer", function: "register_collateral", args: []string{"C1", "vehicle", "", "8000 USD", "2026-01-10", "Alice"}},
+		{name: "duplicate", setup: collateral, caller: "alice", function: "register_collateral", args: []string{"C1", "vehicle", "", "8000 USD", "2026-01-10", "Alice"},
+			code: ErrAlreadyExists},
+		{name: "bad valuation date", caller: "alice", function: "register_collateral", args: []string{"C1", "vehicle", "", "8000 USD", "10/01/2026", "Alice"},
+			code: ErrInvalidArgument},
+		{name: "pledge", setup: collateral, caller: "alice", function: "pledge_collateral", args: []string{"A3", "C1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectPledge("C1", "A3")(t, c, payload)
+				if res := c.agreement("A3"); len(res.Collateral) != 1 || res.Collateral[0] != "C1" {
+					t.Errorf("got %+v", res)
+				}
+				if ev := expectEvent(t, c, EventCollateralPledged, "A3"); ev.CollateralID != "C1" {
+					t.Errorf("got event %+v", ev)
+				}
+			}},
+		{name: "pledge to a second loan", setup: pledged, caller: "alice", function: "pledge_collateral", args: []string{"A1", "C1"}, code: ErrInvalidTransition},
+		{name: "pledge while awaiting signatures", setup: collateral, caller: "alice", function: "pledge_collateral", args: []string{"A2", "C1"}, code: ErrInvalidTransition},
+		{name: "pledge in another currency", caller: "alice", function: "pledge_collateral", args: []string{"A3", "C2"},
+			setup: func(c *testChain) {
+				c.mustInvoke("alice", "register_collateral", "C2", "securities", "", "5000 EUR", "2026-01-10", "Alice")
+			},
+			code: ErrInvalidArgument},
+		{name: "pledge unknown collateral", caller: "alice", function: "pledge_collateral", args: []string{"A3", "C9"}, code: ErrNotFound},
+		{name: "pledge, not a party", setup: collateral, caller: "carol", function: "pledge_collateral", args: []string{"A3", "C1"}, code: ErrForbidden},
+		{name: "pledge someone else's asset", caller: "alice", function: "pledge_collateral", args: []string{"A3", "C3"},
+			setup: func(c *testChain) {
+				c.mustInvoke("carol", "register_collateral", "C3", "vehicle", "", "8000 USD", "2026-01-10", "Carol")
+			},
+			code: ErrForbidden},
+		{name: "servicer pledges the borrower's asset", setup: collateral, caller: "servicer", function: "pledge_collateral", args: []string{"A3", "C1"},
+			check: expectPledge("C1", "A3")},
+		{name: "release", setup: pledged, caller: "bob", function: "release_collateral", args: []string{"A3", "C1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectPledge("C1", "")(t, c, payload)
+				if res := c.agreement("A3"); len(res.Collateral) != 0 {
+					t.Errorf("got %+v", res)
+				}
+				if ev := expectEvent(t, c, EventCollateralReleased, "A3"); ev.CollateralID != "C1" {
+					t.Errorf("got event %+v", ev)
+				}
+			}},
+		{name: "release what is not pledged", setup: collateral, caller: "bob", function: "release_collateral", args: []string{"A3", "C1"}, code: ErrInvalidTransition},
+		{name: "borrowers cannot release", setup: pledged, caller: "alice", function: "release_collateral", args: []string{"A3", "C1"}, code: ErrForbidden},
+		{name: "released on repayment", setup: pledged, caller: "bob", function: "record_repayment", args: []string{"A3", "10000", "2026-02-15", "Alice", "r1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectStatus("A3", StatusRepaid)(t, c, payload)
+				expectPledge("C1", "")(t, c, payload)
+				if ev := expectEvent(t, c, EventCollateralReleased, "A3"); ev.CollateralID != "C1" {
+					t.Errorf("got event %+v", ev)
+				}
+				c.mustInvoke("alice", "pledge_collateral", "A1", "C1")
+				expectPledge("C1", "A1")(t, c, payload)
+			}},
+		{name: "released on cancellation", caller: "bob", function: "update_status", args: []string{"A1", "cancelled"},
+			setup: func(c *testChain) {
+				collateral(c)
+				c.mustInvoke("alice", "pledge_collateral", "A1", "C1")
+			},
+			check: expectPledge("C1", "")},
+	}, false)
+}
+
+func TestCollateralQueries(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "by owner", setup: collateral, caller: "alice", function: "getCollateral_byID", args: []string{"C1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var col Collateral
+				if err := json.Unmarshal(payload, &col); err != nil || col.Owner != "Alice" || col.ValuationDate != "2026-01-10" {
+					t.Errorf("got %s", payload)
+				}
+			}},
+		{name: "by the lender once pledged", setup: pledged, caller: "bob", function: "getCollateral_byID", args: []string{"C1"}},
+		{name: "by the lender while free", setup: collateral, caller: "bob", function: "getCollateral_byID", args: []string{"C1"}, code: ErrForbidden},
+		{name: "by a stranger", setup: pledged, caller: "carol", function: "getCollateral_byID", args: []string{"C1"}, code: ErrForbidden},
+		{name: "unknown id", caller: "auditor", function: "getCollateral_byID", args: []string{"C9"}, code: ErrNotFound},
+		{name: "loan to value", caller: "bob", function: "getLoanToValue", args: []string{"A3"},
+			setup: func(c *testChain) {
+				pledged(c)
+				c.mustInvoke("alice", "register_collateral", "C2", "vehicle", "", "5000 USD", "2026-01-10", "Alice")
+				c.mustInvoke("alice", "pledge_collateral", "A3", "C2")
+				c.mustInvoke("bob", "record_repayment", "A3", "2500", "2026-02-15", "Alice", "r1")
+			},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var ltv LoanToValue
+				if err := json.Unmarshal(payload, &ltv); err != nil {
+					t.Fatalf("got %s", payload)
+				}
+				if ltv.Balance.MinorUnits != 750000 || ltv.CollateralValue.MinorUnits != 2500000 || ltv.LTV == nil || ltv.LTV.BasisPoints != 3000 || len(ltv.Collateral) != 2 {
+					t.Errorf("got %s", payload)
+				}
+			}},
+		{name: "loan to value without collateral", caller: "alice", function: "getLoanToValue", args: []string{"A3"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var ltv LoanToValue
+				if err := json.Unmarshal(payload, &ltv); err != nil || ltv.LTV != nil || ltv.Balance.MinorUnits != 1000000 {
+					t.Errorf("got %s", payload)
+				}
+			}},
+		{name: "loan to value, not a party", caller: "carol", function: "getLoanToValue", args: []string{"A3"}, code: ErrForbidden},
+	}, true)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ============================================================================================================================
+// checkVersion - compare the optional expected version argument of a write with the stored Agreement, empty skips the check
+// ============================================================================================================================
+func checkVersion(res *Agreement, expected string) error {
+	if expected == "" {
+		return nil
+	}
+	version, err := strconv.ParseInt(expected, 10, 64)
+	if err != nil || version < 0 {
+		return invalidField("expected_version", expected, "expecting a whole number")
+	}
+	if version != res.Version {
+		return &ChaincodeError{
+			Code:    ErrConflict,
+			Field:   "expected_version",
+			Value:   expected,
+			Message: fmt.Sprintf("Agreement %s is at version %d, not %d, re-read it and retry", res.AgreeementID, res.Version, version),
+			Details: map[string]interface{}{"agreement_id": res.AgreeementID, "current_version": res.Version},
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// optionalArg - the argument at index i, empty when the client left it out
+// ============================================================================================================================
+func optionalArg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-contract-api-go/metadata"
+)
+
+// LoanContract - one transaction per Agreement function for the contract API, which publishes their metadata.
+// Every transaction runs through ManageLoan, so access policies, events and errors are the same under either name.
+// Function names the contract does not know, the original create_agreement, getAgreement_byID and so on,
+// reach ManageLoan unchanged through the legacy adapter.
+type LoanContract struct {
+	contractapi.Contract
+	loans ManageLoan
+}
+
+// ============================================================================================================================
+// newChaincode - the contract API chaincode serving LoanContract with the legacy adapter for unknown function names
+// ============================================================================================================================
+func newChaincode() (*contractapi.ContractChaincode, error) {
+	contract := new(LoanContract)
+	contract.Name = "LoanContract"
+	contract.Info = metadata.InfoMetadata{
+		Title:       "Loan agreements",
+		Description: "Loan agreements between a borrower and a lender, from draft to repaid",
+		Version:     strconv.Itoa(SchemaVersion),
+	}
+	contract.UnknownTransaction = contract.legacy
+	chaincode, err := contractapi.NewChaincode(contract)
+	if err != nil {
+		return nil, err
+	}
+	chaincode.DefaultContract = contract.Name //unqualified function names, the legacy ones included, go to LoanContract
+	chaincode.Info = contract.Info
+	return chaincode, nil
+}
+
+// ============================================================================================================================
+// legacy - route a function the contract does not know to ManageLoan by its original name
+// ============================================================================================================================
+func (c *LoanContract) legacy(ctx contractapi.TransactionContextInterface) (string, error) {
+	function, args := ctx.GetStub().GetFunctionAndParameters()
+	if _, ok := invokePolicies[function]; ok {
+		return c.submit(ctx, function, args...)
+	}
+	return c.evaluate(ctx, function, args...)
+}
+
+// ============================================================================================================================
+// submit / evaluate - run an original write or read function with positional arguments
+// ============================================================================================================================
+func (c *LoanContract) submit(ctx contractapi.TransactionContextInterface, function string, args ...string) (string, error) {
+	payload, err := c.loans.Invoke(ctx.GetStub(), function, args)
+	return string(payload), err
+}
+
+func (c *LoanContract) evaluate(ctx contractapi.TransactionContextInterface, function string, args ...string) (string, error) {
+	payload, err := c.loans.Query(ctx.GetStub(), function, args)
+	return string(payload), err
+}
+
+// optionalVersion / optionalPageSize - zero leaves the positional argument out
+func optionalVersion(version int64) string {
+	if version == 0 {
+		return ""
+	}
+	return strconv.FormatInt(version, 10)
+}
+
+func optionalPageSize(pageSize int32) string {
+	if pageSize == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(pageSize))
+}
+
+// InitLedger - store the test value and the comma separated MSP IDs allowed to grant the admin role, see Init
+func (c *LoanContract) InitLedger(ctx contractapi.TransactionContextInterface, message string, adminMSPs string) error {
+	_, err := c.submit(ctx, "init", message, adminMSPs)
+	return err
+}
+
+// SetRoleMSPs - set the comma separated MSP IDs allowed to grant a role
+func (c *LoanContract) SetRoleMSPs(ctx contractapi.TransactionContextInterface, role string, msps string) error {
+	_, err := c.submit(ctx, "set_role_msps", role, msps)
+	return err
+}
+
+// CreateAgreement - create a draft or proposed Agreement, see create_agreement
+func (c *LoanContract) CreateAgreement(ctx contractapi.TransactionContextInterface, agreementID string, borrowerID string, lenderID string, agreementDate string, loanAmount string, status string, interestRate string, loanDuration string, repaymentDate string, comments string, repaymentType string) error {
+	_, err := c.submit(ctx, "create_agreement", agreementID, borrowerID, lenderID, agreementDate, loanAmount, status, interestRate, loanDuration, repaymentDate, "", "", comments, repaymentType)
+	return err
+}
+
+// UpdateAgreementTerms - replace every term of an Agreement, see update_po, an expected version of 0 skips the check
+func (c *LoanContract) UpdateAgreementTerms(ctx contractapi.TransactionContextInterface, agreementID string, borrowerID string, lenderID string, agreementDate string, loanAmount string, status string, interestRate string, loanDuration string, repaymentDate string, comments string, expectedVersion int64) error {
+	_, err := c.submit(ctx, "update_po", agreementID, borrowerID, lenderID, agreementDate, loanAmount, status, interestRate, loanDuration, repaymentDate, "", "", comments, optionalVersion(expectedVersion))
+	return err
+}
+
+// UpdateAgreement - change the fields named in a JSON patch, see update_agreement
+func (c *LoanContract) UpdateAgreement(ctx contractapi.TransactionContextInterface, agreementID string, patch string, expectedVersion int64) error {
+	_, err := c.submit(ctx, "update_agreement", agreementID, patch, optionalVersion(expectedVersion))
+	return err
+}
+
+// UpdateStatus - move an Agreement to another lifecycle status, see update_status
+func (c *LoanContract) UpdateStatus(ctx contractapi.TransactionContextInterface, agreementID string, status string, expectedVersion int64) error {
+	_, err := c.submit(ctx, "update_status", agreementID, status, optionalVersion(expectedVersion))
+	return err
+}
+
+// ArchiveAgreement - take an Agreement off the book with a reason, see delete_po
+func (c *LoanContract) ArchiveAgreement(ctx contractapi.TransactionContextInterface, agreementID string, reason string, expectedVersion int64) error {
+	_, err := c.submit(ctx, "delete_po", agreementID, reason, optionalVersion(expectedVersion))
+	return err
+}
+
+// RestoreAgreement - bring an archived Agreement back, see restore_agreement
+func (c *LoanContract) RestoreAgreement(ctx contractapi.TransactionContextInterface, agreementID string, expectedVersion int64) error {
+	_, err := c.submit(ctx, "restore_agreement", agreementID, optionalVersion(expectedVersion))
+	return err
+}
+
+// PurgeAgreement - delete a never signed draft, see purge_agreement
+func (c *LoanContract) PurgeAgreement(ctx contractapi.TransactionContextInterface, agreementID string, expectedVersion int64) error {
+	_, err := c.submit(ctx, "purge_agreement", agreementID, optionalVersion(expectedVersion))
+	return err
+}
+
+// BorrowerSign - the borrower signs a proposed Agreement, see borrower_sign
+func (c *LoanContract) BorrowerSign(ctx contractapi.TransactionContextInterface, agreementID string, expectedVersion int64) error {
+	_, err := c.submit(ctx, "borrower_sign", agreementID, optionalVersion(expectedVersion))
+	return err
+}
+
+// LenderSign - the lender signs a proposed Agreement, see lender_sign
+func (c *LoanContract) LenderSign(ctx contractapi.TransactionContextInterface, agreementID string, expectedVersion int64) error {
+	_, err := c.submit(ctx, "lender_sign", agreementID, optionalVersion(expectedVersion))
+	return err
+}
+
+// RecordRepayment - record money repaid on an active Agreement, see record_repayment
+func (c *LoanContract) RecordRepayment(ctx contractapi.TransactionContextInterface, agreementID string, amount string, date string, payer string, reference string, expectedVersion int64) error {
+	_, err := c.submit(ctx, "record_repayment", agreementID, amount, date, payer, reference, optionalVersion(expectedVersion))
+	return err
+}
+
+// AccrueInterest - store the interest an active Agreement accrued up to the transaction date, see accrue_interest
+func (c *LoanContract) AccrueInterest(ctx contractapi.TransactionContextInterface, agreementID string, expectedVersion int64) (string, error) {
+	return c.submit(ctx, "accrue_interest", agreementID, optionalVersion(expectedVersion))
+}
+
+// ProposeAmendment - propose new terms for a signed Agreement and return the amendment, see propose_amendment
+func (c *LoanContract) ProposeAmendment(ctx contractapi.TransactionContextInterface, agreementID string, changes string, reason string, expectedVersion int64) (string, error) {
+	return c.submit(ctx, "propose_amendment", agreementID, changes, reason, optionalVersion(expectedVersion))
+}
+
+// ApproveAmendment - accept and apply the counter-party's amendment, see approve_amendment
+func (c *LoanContract) ApproveAmendment(ctx contractapi.TransactionContextInterface, agreementID string, amendmentID string, expectedVersion int64) (string, error) {
+	return c.submit(ctx, "approve_amendment", agreementID, amendmentID, optionalVersion(expectedVersion))
+}
+
+// RejectAmendment - decline the counter-party's amendment or withdraw one's own, see reject_amendment
+func (c *LoanContract) RejectAmendment(ctx contractapi.TransactionContextInterface, agreementID string, amendmentID string, reason string, expectedVersion int64) (string, error) {
+	return c.submit(ctx, "reject_amendment", agreementID, amendmentID, reason, optionalVersion(expectedVersion))
+}
+
+// RegisterParty - record a borrower or lender and the identity acting for it, see register_party
+func (c *LoanContract) RegisterParty(ctx contractapi.TransactionContextInterface, partyID string, legalName string, partyType string, mspID string, identity string, contactHash string) error {
+	_, err := c.submit(ctx, "register_party", partyID, legalName, partyType, mspID, identity, contactHash)
+	return err
+}
+
+// UpdateParty - change the fields of a party named in a JSON patch, see update_party
+func (c *LoanContract) UpdateParty(ctx contractapi.TransactionContextInterface, partyID string, patch string) error {
+	_, err := c.submit(ctx, "update_party", partyID, patch)
+	return err
+}
+
+// RegisterCollateral - record a collateral asset with its appraised value, see register_collateral
+func (c *LoanContract) RegisterCollateral(ctx contractapi.TransactionContextInterface, collateralID string, collateralType string, description string, appraisedValue string, valuationDate string, owner string) error {
+	_, err := c.submit(ctx, "register_collateral", collateralID, collateralType, description, appraisedValue, valuationDate, owner)
+	return err
+}
+
+// PledgeCollateral - secure an Agreement with a free collateral asset, see pledge_collateral
+func (c *LoanContract) PledgeCollateral(ctx contractapi.TransactionContextInterface, agreementID string, collateralID string, expectedVersion int64) error {
+	_, err := c.submit(ctx, "pledge_collateral", agreementID, collateralID, optionalVersion(expectedVersion))
+	return err
+}
+
+// ReleaseCollateral - free a collateral asset from an Agreement, see release_collateral
+func (c *LoanContract) ReleaseCollateral(ctx contractapi.TransactionContextInterface, agreementID string, collateralID string, expectedVersion int64) error {
+	_, err := c.submit(ctx, "release_collateral", agreementID, collateralID, optionalVersion(expectedVersion))
+	return err
+}
+
+// CheckOverdue - flag loans past their repayment date as overdue and default the ones overdue too long, see check_overdue
+func (c *LoanContract) CheckOverdue(ctx contractapi.TransactionContextInterface) (string, error) {
+	return c.submit(ctx, "check_overdue")
+}
+
+// SetOverduePolicy - configure the penalty rate, grace period and days until default, see set_overdue_policy
+func (c *LoanContract) SetOverduePolicy(ctx contractapi.TransactionContextInterface, penaltyRate string, graceDays int, defaultAfterDays int) error {
+	_, err := c.submit(ctx, "set_overdue_policy", penaltyRate, strconv.Itoa(graceDays), strconv.Itoa(defaultAfterDays))
+	return err
+}
+
+// MigrateIndex - move Agreements from a legacy _LoanIndex array to the composite key indexes, 0 moves them all, see migrate_index
+func (c *LoanContract) MigrateIndex(ctx contractapi.TransactionContextInterface, batchSize int) (string, error) {
+	if batchSize == 0 {
+		return c.submit(ctx, "migrate_index")
+	}
+	return c.submit(ctx, "migrate_index", strconv.Itoa(batchSize))
+}
+
+// GetAgreement - an Agreement as JSON, see getAgreement_byID
+func (c *LoanContract) GetAgreement(ctx contractapi.TransactionContextInterface, agreementID string) (string, error) {
+	return c.evaluate(ctx, "getAgreement_byID", agreementID)
+}
+
+// GetAgreementsByLender - a page of a lender's Agreements, see getAgreement_byBuyer
+func (c *LoanContract) GetAgreementsByLender(ctx contractapi.TransactionContextInterface, lenderID string, pageSize int32, bookmark string, includeArchived bool) (string, error) {
+	return c.evaluate(ctx, "getAgreement_byBuyer", lenderID, optionalPageSize(pageSize), bookmark, strconv.FormatBool(includeArchived))
+}
+
+// GetAgreementsByBorrower - a page of a borrower's Agreements, see getAgreement_bySeller
+func (c *LoanContract) GetAgreementsByBorrower(ctx contractapi.TransactionContextInterface, borrowerID string, pageSize int32, bookmark string, includeArchived bool) (string, error) {
+	return c.evaluate(ctx, "getAgreement_bySeller", borrowerID, optionalPageSize(pageSize), bookmark, strconv.FormatBool(includeArchived))
+}
+
+// GetAllAgreements - a page of every Agreement, see get_AllAgreement
+func (c *LoanContract) GetAllAgreements(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string, includeArchived bool) (string, error) {
+	return c.evaluate(ctx, "get_AllAgreement", optionalPageSize(pageSize), bookmark, strconv.FormatBool(includeArchived))
+}
+
+// GetRepayments - the repayments recorded against an Agreement, see getRepayments_byAgreement
+func (c *LoanContract) GetRepayments(ctx contractapi.TransactionContextInterface, agreementID string) (string, error) {
+	return c.evaluate(ctx, "getRepayments_byAgreement", agreementID)
+}
+
+// GetSchedule - the installment schedule of an Agreement, see getSchedule
+func (c *LoanContract) GetSchedule(ctx contractapi.TransactionContextInterface, agreementID string) (string, error) {
+	return c.evaluate(ctx, "getSchedule", agreementID)
+}
+
+// GetAccruedInterest - the interest accrued on an Agreement as of a date, empty for the transaction date, see getAccruedInterest
+func (c *LoanContract) GetAccruedInterest(ctx contractapi.TransactionContextInterface, agreementID string, asOfDate string) (string, error) {
+	return c.evaluate(ctx, "getAccruedInterest", agreementID, asOfDate)
+}
+
+// GetAmendments - every amendment of an Agreement and its outcome as JSON, see getAmendments_byAgreement
+func (c *LoanContract) GetAmendments(ctx contractapi.TransactionContextInterface, agreementID string) (string, error) {
+	return c.evaluate(ctx, "getAmendments_byAgreement", agreementID)
+}
+
+// GetParty - a registered party as JSON, see getParty_byID
+func (c *LoanContract) GetParty(ctx contractapi.TransactionContextInterface, partyID string) (string, error) {
+	return c.evaluate(ctx, "getParty_byID", partyID)
+}
+
+// GetCollateral - a collateral asset as JSON, see getCollateral_byID
+func (c *LoanContract) GetCollateral(ctx contractapi.TransactionContextInterface, collateralID string) (string, error) {
+	return c.evaluate(ctx, "getCollateral_byID", collateralID)
+}
+
+// GetLoanToValue - the outstanding balance of an Agreement against the value of its collateral, see getLoanToValue
+func (c *LoanContract) GetLoanToValue(ctx contractapi.TransactionContextInterface, agreementID string) (string, error) {
+	return c.evaluate(ctx, "getLoanToValue", agreementID)
+}
+
+// GetOverduePolicy - the policy check_overdue applies, see getOverduePolicy
+func (c *LoanContract) GetOverduePolicy(ctx contractapi.TransactionContextInterface) (string, error) {
+	return c.evaluate(ctx, "getOverduePolicy")
+}
+
+// GetAgreementsByStatus - a page of the Agreements in a lifecycle status, see getAgreement_byStatus
+func (c *LoanContract) GetAgreementsByStatus(ctx contractapi.TransactionContextInterface, status string, pageSize int32, bookmark string, includeArchived bool) (string, error) {
+	return c.evaluate(ctx, "getAgreement_byStatus", status, optionalPageSize(pageSize), bookmark, strconv.FormatBool(includeArchived))
+}
+
+// GetAgreementsByRepaymentDate - a page of the Agreements due between two dates, see getAgreement_byRepaymentDate
+func (c *LoanContract) GetAgreementsByRepaymentDate(ctx contractapi.TransactionContextInterface, from string, to string, pageSize int32, bookmark string, includeArchived bool) (string, error) {
+	return c.evaluate(ctx, "getAgreement_byRepaymentDate", from, to, optionalPageSize(pageSize), bookmark, strconv.FormatBool(includeArchived))
+}
+
+// QueryAgreements - a page of the Agreements matching a JSON filter, see query_agreements
+func (c *LoanContract) QueryAgreements(ctx contractapi.TransactionContextInterface, query string, pageSize int32, bookmark string, includeArchived bool) (string, error) {
+	return c.evaluate(ctx, "query_agreements", query, optionalPageSize(pageSize), bookmark, strconv.FormatBool(includeArchived))
+}
+
+// GetAgreementHistory - every version of an Agreement, see getAgreement_history
+func (c *LoanContract) GetAgreementHistory(ctx contractapi.TransactionContextInterface, agreementID string, diff bool) (string, error) {
+	return c.evaluate(ctx, "getAgreement_history", agreementID, strconv.FormatBool(diff))
+}
+
+// GetSchema - the argument schema of the original function names, see get_schema
+func (c *LoanContract) GetSchema(ctx contractapi.TransactionContextInterface, function string) (string, error) {
+	return c.evaluate(ctx, "get_schema", function)
+}
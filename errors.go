@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ErrorCode - what went wrong, clients branch on the code and never on the message
+type ErrorCode string
+
+const (
+	ErrNotFound          ErrorCode = "NOT_FOUND"          // the Agreement or record does not exist
+	ErrAlreadyExists     ErrorCode = "ALREADY_EXISTS"     // the id or signature is taken
+	ErrInvalidArgument   ErrorCode = "INVALID_ARGUMENT"   // an argument is missing or malformed, Field names it
+	ErrForbidden         ErrorCode = "FORBIDDEN"          // the caller's roles or party do not allow it
+	ErrConflict          ErrorCode = "CONFLICT"           // the Agreement changed since the client read it
+	ErrInvalidTransition ErrorCode = "INVALID_TRANSITION" // the Agreement is in a status that does not allow it
+	ErrInternal          ErrorCode = "INTERNAL"           // the ledger or a stored document could not be read or written
+)
+
+type ChaincodeError struct { // Every error ManageLoan returns, reported as JSON
+	Code    ErrorCode              `json:"code"`
+	Message string                 `json:"message"`
+	Field   string                 `json:"field,omitempty"`
+	Value   string                 `json:"value,omitempty"`
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// Error - the error as a JSON object
+func (e *ChaincodeError) Error() string {
+	jsonAsBytes, _ := json.Marshal(e)
+	return string(jsonAsBytes)
+}
+
+// ============================================================================================================================
+// invalidField - an argument or field with a value that cannot be used
+// ============================================================================================================================
+func invalidField(field, value, message string) error {
+	return &ChaincodeError{Code: ErrInvalidArgument, Field: field, Value: value, Message: message}
+}
+
+// ============================================================================================================================
+// invalidArgs - the wrong number of arguments
+// ============================================================================================================================
+func invalidArgs(message string) error {
+	return &ChaincodeError{Code: ErrInvalidArgument, Field: "args", Message: message}
+}
+
+// ============================================================================================================================
+// notFound - nothing stored under the id given in a field
+// ============================================================================================================================
+func notFound(field, value, message string) error {
+	return &ChaincodeError{Code: ErrNotFound, Field: field, Value: value, Message: message}
+}
+
+// ============================================================================================================================
+// alreadyExists - something is already stored where a new record would go
+// ============================================================================================================================
+func alreadyExists(field, value, message string) error {
+	return &ChaincodeError{Code: ErrAlreadyExists, Field: field, Value: value, Message: message}
+}
+
+// ============================================================================================================================
+// forbidden - the caller may not do this
+// ============================================================================================================================
+func forbidden(format string, a ...interface{}) error {
+	return &ChaincodeError{Code: ErrForbidden, Message: fmt.Sprintf(format, a...)}
+}
+
+// ============================================================================================================================
+// invalidTransition - the status or archive state of an Agreement rules the request out
+// ============================================================================================================================
+func invalidTransition(field string, format string, a ...interface{}) error {
+	return &ChaincodeError{Code: ErrInvalidTransition, Field: field, Message: fmt.Sprintf(format, a...)}
+}
+
+// ============================================================================================================================
+// asChaincodeError - errors from the ledger and other libraries become INTERNAL
+// ============================================================================================================================
+func asChaincodeError(err error) *ChaincodeError {
+	if e, ok := err.(*ChaincodeError); ok {
+		return e
+	}
+	return &ChaincodeError{Code: ErrInternal, Message: err.Error()}
+}
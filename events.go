@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// EventVersion - version of the event payload, bumped whenever a field changes meaning or goes away
+const EventVersion = 1
+
+// EventType - chaincode event name listeners subscribe to
+type EventType string
+
+const (
+	EventAgreementCreated   EventType = "AgreementCreated"
+	EventAgreementUpdated   EventType = "AgreementUpdated"
+	EventAgreementArchived  EventType = "AgreementArchived"
+	EventAgreementRestored  EventType = "AgreementRestored"
+	EventAgreementDeleted   EventType = "AgreementDeleted"
+	EventStatusChanged      EventType = "AgreementStatusChanged"
+	EventAgreementSigned    EventType = "AgreementSigned"
+	EventRepaymentRecorded  EventType = "RepaymentRecorded"
+	EventInterestAccrued    EventType = "InterestAccrued"
+	EventAgreementOverdue   EventType = "AgreementOverdue"
+	EventCollateralPledged  EventType = "CollateralPledged"
+	EventCollateralReleased EventType = "CollateralReleased"
+	EventAmendmentProposed  EventType = "AmendmentProposed"
+	EventAmendmentApproved  EventType = "AmendmentApproved"
+	EventAmendmentRejected  EventType = "AmendmentRejected" // rejected or withdrawn
+)
+
+// EventBatchName - event name used when a transaction emits more than one event,
+// the ledger keeps only one event per transaction so they travel together as a JSON array
+const EventBatchName = "AgreementEvents"
+
+type AgreementEvent struct { // Payload of every chaincode event
+	EventVersion int        `json:"event_version"`
+	EventType    EventType  `json:"event_type"`
+	AgreementID  string     `json:"agreement_id"`
+	OldStatus    LoanStatus `json:"old_status,omitempty"`
+	NewStatus    LoanStatus `json:"new_status,omitempty"`
+	CollateralID string     `json:"collateral_id,omitempty"` // asset pledged or released, collateral events only
+	Actor        string     `json:"actor"`
+	TxID         string     `json:"tx_id"`
+}
+
+// eventStub - stub handed to Invoke functions, collecting their events until the transaction succeeds
+type eventStub struct {
+	shim.ChaincodeStubInterface
+	events []AgreementEvent
+}
+
+// ============================================================================================================================
+// emitEvent - queue an event about an Agreement, old or new status is empty when the Agreement is created or deleted
+// ============================================================================================================================
+func emitEvent(stub shim.ChaincodeStubInterface, eventType EventType, agreement_id string, from, to LoanStatus) error {
+	return queueEvent(stub, AgreementEvent{EventType: eventType, AgreementID: agreement_id, OldStatus: from, NewStatus: to})
+}
+
+// ============================================================================================================================
+// emitCollateralEvent - queue a pledge or release of a collateral asset, the status of the Agreement is unchanged
+// ============================================================================================================================
+func emitCollateralEvent(stub shim.ChaincodeStubInterface, eventType EventType, res *Agreement, collateral_id string) error {
+	status := currentStatus(res)
+	return queueEvent(stub, AgreementEvent{EventType: eventType, AgreementID: res.AgreeementID, OldStatus: status, NewStatus: status, CollateralID: collateral_id})
+}
+
+// ============================================================================================================================
+// queueEvent - stamp an event with the version, actor and tx id and queue it
+// ============================================================================================================================
+func queueEvent(stub shim.ChaincodeStubInterface, event AgreementEvent) error {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	event.EventVersion = EventVersion
+	event.Actor = caller.String()
+	event.TxID = stub.GetTxID()
+	if es, ok := stub.(*eventStub); ok {
+		es.events = append(es.events, event)
+		return nil
+	}
+	return setEvents(stub, []AgreementEvent{event})
+}
+
+// ============================================================================================================================
+// flush - set the queued events on the transaction
+// ============================================================================================================================
+func (es *eventStub) flush() error {
+	if len(es.events) == 0 {
+		return nil
+	}
+	return setEvents(es.ChaincodeStubInterface, es.events)
+}
+
+// ============================================================================================================================
+// setEvents - a single event goes out under its own type, several under EventBatchName
+// ============================================================================================================================
+func setEvents(stub shim.ChaincodeStubInterface, events []AgreementEvent) error {
+	name := EventBatchName
+	var payload interface{} = events
+	if len(events) == 1 {
+		name, payload = string(events[0].EventType), events[0]
+	}
+	jsonAsBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("Failed to encode event %s: %s", name, err)
+	}
+	return stub.SetEvent(name, jsonAsBytes)
+}
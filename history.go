@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+type HistoryEntry struct { // One version of an Agreement as written by a transaction
+	TxID      string        `json:"tx_id"`
+	Timestamp string        `json:"timestamp"`
+	IsDelete  bool          `json:"is_delete"`
+	Record    *Agreement    `json:"record,omitempty"`
+	Changes   []FieldChange `json:"changes,omitempty"`
+}
+
+type FieldChange struct { // A field that differs from the previous version, Old or New is absent when the field was added or removed
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old,omitempty"`
+	New   json.RawMessage `json:"new,omitempty"`
+}
+
+// ============================================================================================================================
+// getAgreement_history - every version of an Agreement, oldest first, optionally with the fields each version changed
+// ============================================================================================================================
+func (t *ManageLoan) getAgreement_history(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id and an optional diff flag")
+	}
+	fmt.Println("start getAgreement_history")
+	agreement_id := args[0]
+	withChanges := false
+	if len(args) == 2 {
+		var err error
+		if withChanges, err = strconv.ParseBool(args[1]); err != nil {
+			return nil, invalidField("diff", args[1], "expecting true or false")
+		}
+	}
+	iter, err := stub.GetHistoryForKey(agreement_id)
+	if err != nil {
+		return nil, errors.New("Failed to get history for " + agreement_id)
+	}
+	defer iter.Close()
+
+	history := []HistoryEntry{}
+	var latest *Agreement
+	var previous map[string]json.RawMessage
+	for iter.HasNext() {
+		mod, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		entry := HistoryEntry{TxID: mod.TxId, IsDelete: mod.IsDelete}
+		if mod.Timestamp != nil {
+			entry.Timestamp = time.Unix(mod.Timestamp.Seconds, int64(mod.Timestamp.Nanos)).UTC().Format(time.RFC3339Nano)
+		}
+		var fields map[string]json.RawMessage
+		if !mod.IsDelete {
+			if entry.Record, err = decodeAgreement(agreement_id, mod.Value); err != nil { //old versions come back at the current schema
+				return nil, err
+			}
+			latest = entry.Record
+			if fields, err = documentFields(entry.Record); err != nil {
+				return nil, err
+			}
+		}
+		if withChanges {
+			entry.Changes = diffFields(previous, fields)
+		}
+		previous = fields
+		history = append(history, entry)
+	}
+	if latest == nil {
+		return nil, notFound("agreement_id", agreement_id, "Agreement not found: "+agreement_id)
+	}
+	if err = authorizeAgreement(stub, latest); err != nil { //parties to the last stored version see every version
+		return nil, err
+	}
+	fmt.Println("end getAgreement_history")
+	return json.Marshal(history)
+}
+
+// ============================================================================================================================
+// documentFields - the top level JSON fields of a document
+// ============================================================================================================================
+func documentFields(doc interface{}) (map[string]json.RawMessage, error) {
+	jsonAsBytes, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	err = json.Unmarshal(jsonAsBytes, &fields)
+	return fields, err
+}
+
+// ============================================================================================================================
+// diffFields - the fields that were added, removed or changed between two versions, sorted by name
+// ============================================================================================================================
+func diffFields(old, new map[string]json.RawMessage) []FieldChange {
+	names := map[string]bool{}
+	for name := range old {
+		names[name] = true
+	}
+	for name := range new {
+		names[name] = true
+	}
+	changes := []FieldChange{}
+	for name := range names {
+		before, after := old[name], new[name]
+		if !bytes.Equal(before, after) {
+			changes = append(changes, FieldChange{Field: name, Old: before, New: after})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
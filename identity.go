@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Caller - identity of the client that submitted the current transaction
+type Caller struct {
+	MSPID       string
+	ID          string // unique id of the enrollment certificate within its MSP
+	Name        string // common name of the enrollment certificate
+	Party       string // id of the registered party bound to the caller's MSP ID and common name, see resolveParty
+	Fingerprint string // hex SHA-256 of the DER encoded certificate
+	Roles       []Role // roles granted by the loan.role attribute
+}
+
+// String - short form of the caller used as the actor on ledger records
+func (c Caller) String() string {
+	return c.MSPID + "/" + c.Name
+}
+
+// ============================================================================================================================
+// getCaller - read the submitting client's identity from the transaction creator
+// ============================================================================================================================
+func getCaller(stub shim.ChaincodeStubInterface) (Caller, error) {
+	var caller Caller
+	mspID, err := cid.GetMSPID(stub)
+	if err != nil {
+		return caller, errors.New("Failed to get caller MSP ID")
+	}
+	id, err := cid.GetID(stub)
+	if err != nil {
+		return caller, errors.New("Failed to get caller ID")
+	}
+	cert, err := cid.GetX509Certificate(stub)
+	if err != nil || cert == nil {
+		return caller, errors.New("Failed to get caller certificate")
+	}
+	caller.MSPID = mspID
+	caller.ID = id
+	caller.Name = cert.Subject.CommonName
+	if caller.Party, err = resolveParty(stub, caller); err != nil {
+		return caller, err
+	}
+	roles, found, err := cid.GetAttributeValue(stub, RoleAttribute)
+	if err != nil {
+		return caller, errors.New("Failed to read caller attribute " + RoleAttribute)
+	}
+	if found {
+		caller.Roles = parseRoles(roles)
+	}
+	sum := sha256.Sum256(cert.Raw)
+	caller.Fingerprint = hex.EncodeToString(sum[:])
+	return caller, nil
+}
+
+// ============================================================================================================================
+// txTime - timestamp of the current transaction, identical on every endorsing peer
+// ============================================================================================================================
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil || ts == nil {
+		return time.Time{}, errors.New("Failed to get transaction timestamp")
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
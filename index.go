@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// Indexes, composite keys of the indexed value and the agreement id with an empty value.
+// AgreementIndex lists every Agreement by id alone.
+const (
+	AgreementIndex     = "agreement~id"
+	BorrowerIndex      = "borrower~id"
+	LenderIndex        = "lender~id"
+	StatusIndex        = "status~id"
+	RepaymentDateIndex = "repaymentdate~id"
+)
+
+// indexValue - composite key entries carry no data, the key itself is the index
+var indexValue = []byte{0x00}
+
+// ============================================================================================================================
+// indexKeys - every index key an Agreement should have
+// ============================================================================================================================
+func indexKeys(stub shim.ChaincodeStubInterface, res *Agreement) (map[string]bool, error) {
+	keys := map[string]bool{}
+	key, err := stub.CreateCompositeKey(AgreementIndex, []string{res.AgreeementID})
+	if err != nil {
+		return nil, err
+	}
+	keys[key] = true
+	entries := [][]string{
+		{BorrowerIndex, res.BorrowerID},
+		{LenderIndex, res.LenderID},
+		{StatusIndex, string(currentStatus(res))},
+	}
+	if res.RepaymentDate != "" {
+		entries = append(entries, []string{RepaymentDateIndex, res.RepaymentDate})
+	}
+	for _, entry := range entries {
+		key, err := stub.CreateCompositeKey(entry[0], []string{entry[1], res.AgreeementID})
+		if err != nil {
+			return nil, err
+		}
+		keys[key] = true
+	}
+	return keys, nil
+}
+
+// ============================================================================================================================
+// updateIndexes - move the index entries of an Agreement from its previous version (nil when new) to its current one
+// ============================================================================================================================
+func updateIndexes(stub shim.ChaincodeStubInterface, old, res *Agreement) error {
+	oldKeys := map[string]bool{}
+	newKeys := map[string]bool{}
+	var err error
+	if old != nil {
+		if oldKeys, err = indexKeys(stub, old); err != nil {
+			return err
+		}
+	}
+	if res != nil {
+		if newKeys, err = indexKeys(stub, res); err != nil {
+			return err
+		}
+	}
+	for key := range oldKeys {
+		if !newKeys[key] {
+			if err = stub.DelState(key); err != nil {
+				return err
+			}
+		}
+	}
+	for key := range newKeys {
+		if !oldKeys[key] {
+			if err = stub.PutState(key, indexValue); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// getAgreement_byStatus - get the Agreements currently in a lifecycle status
+// ============================================================================================================================
+func (t *ManageLoan) getAgreement_byStatus(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting status, optional page size, bookmark and include archived flag")
+	}
+	fmt.Println("start getAgreement_byStatus")
+	status, err := parseStatus(args[0])
+	if err != nil {
+		return nil, err
+	}
+	paging, err := parsePaging(args[1:])
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end getAgreement_byStatus")
+	return agreementPage(stub, StatusIndex, []string{string(status)}, paging)
+}
+
+// ============================================================================================================================
+// getAgreement_byRepaymentDate - get one page of the Agreements with a repayment date between two dates, both inclusive
+// ============================================================================================================================
+func (t *ManageLoan) getAgreement_byRepaymentDate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 2 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting from, to, optional page size, bookmark and include archived flag")
+	}
+	fmt.Println("start getAgreement_byRepaymentDate")
+	from, to := args[0], args[1]
+	if _, err := time.Parse(DateLayout, from); err != nil {
+		return nil, invalidField("from", from, "expecting YYYY-MM-DD")
+	}
+	if _, err := time.Parse(DateLayout, to); err != nil {
+		return nil, invalidField("to", to, "expecting YYYY-MM-DD")
+	}
+	paging, err := parsePaging(args[2:])
+	if err != nil {
+		return nil, err
+	}
+	start := paging.Bookmark
+	if start == "" { //index keys sort by date, the first page starts at from
+		if start, err = stub.CreateCompositeKey(RepaymentDateIndex, []string{from}); err != nil {
+			return nil, err
+		}
+	}
+	iter, meta, err := stub.GetStateByPartialCompositeKeyWithPagination(RepaymentDateIndex, []string{}, paging.PageSize, start)
+	if err != nil {
+		return nil, errors.New("Failed to read index " + RepaymentDateIndex)
+	}
+	defer iter.Close()
+	page := Page{Records: []*Agreement{}}
+	read, past := 0, false
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		read++
+		_, parts, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		if parts[0] > to { //only the Agreements in range are read
+			past = true
+			break
+		}
+		if parts[0] < from {
+			continue
+		}
+		res, err := getAgreement(stub, parts[1])
+		if err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, res)
+	}
+	if !past && meta != nil && read == int(paging.PageSize) { //a short page, or one reaching past to, is the last one
+		page.Bookmark = meta.Bookmark
+	}
+	page.Records = unarchived(page.Records, paging.IncludeArchived)
+	if page.Records, err = visibleAgreements(stub, page.Records); err != nil {
+		return nil, err
+	}
+	page.Count = len(page.Records)
+	fmt.Println("end getAgreement_byRepaymentDate")
+	return json.Marshal(page)
+}
+
+// ============================================================================================================================
+// migrate_index - move the Agreements listed in a legacy _LoanIndex array to composite key indexes, optionally in batches
+// ============================================================================================================================
+func (t *ManageLoan) migrate_index(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting an optional batch size")
+	}
+	fmt.Println("start migrate_index")
+	poIndexAsBytes, err := stub.GetState(LoanIndexStr)
+	if err != nil {
+		return nil, errors.New("Failed to get Agreement index")
+	}
+	var poIndex []string
+	if poIndexAsBytes != nil {
+		if err = json.Unmarshal(poIndexAsBytes, &poIndex); err != nil {
+			return nil, errors.New("Failed to decode Agreement index")
+		}
+	}
+	batch := len(poIndex)
+	if len(args) == 1 {
+		batch, err = strconv.Atoi(args[0])
+		if err != nil || batch <= 0 {
+			return nil, invalidField("batch_size", args[0], "expecting a whole number greater than zero")
+		}
+		if batch > len(poIndex) {
+			batch = len(poIndex)
+		}
+	}
+
+	migrated, skipped := 0, 0
+	for _, agreement_id := range poIndex[:batch] {
+		poAsBytes, err := stub.GetState(agreement_id)
+		if err != nil {
+			return nil, errors.New("Failed to get state for " + agreement_id)
+		}
+		if poAsBytes == nil { //listed but already deleted
+			skipped++
+			continue
+		}
+		res, err := decodeAgreement(agreement_id, poAsBytes)
+		if err != nil {
+			return nil, err
+		}
+		//the stored record changes, clients holding the legacy one must read it again
+		res.Version++
+		if err = putDocument(stub, agreement_id, res); err != nil { //store it at the current schema version on the way
+			return nil, err
+		}
+		if err = updateIndexes(stub, nil, res); err != nil {
+			return nil, err
+		}
+		migrated++
+	}
+
+	remaining := poIndex[batch:]
+	if len(remaining) == 0 {
+		err = stub.DelState(LoanIndexStr)
+	} else {
+		poIndexAsBytes, _ = json.Marshal(remaining)
+		err = stub.PutState(LoanIndexStr, poIndexAsBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end migrate_index")
+	return json.Marshal(map[string]int{"migrated": migrated, "skipped": skipped, "remaining": len(remaining)})
+}
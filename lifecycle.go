@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// LoanStatus - a state in the Agreement lifecycle
+type LoanStatus string
+
+const (
+	StatusDraft     LoanStatus = "draft"
+	StatusProposed  LoanStatus = "proposed"
+	StatusSigned    LoanStatus = "signed"
+	StatusActive    LoanStatus = "active"
+	StatusOverdue   LoanStatus = "overdue"
+	StatusRepaid    LoanStatus = "repaid"
+	StatusDefaulted LoanStatus = "defaulted"
+	StatusCancelled LoanStatus = "cancelled"
+)
+
+// allowedTransitions - for every status, the statuses an Agreement may move to next
+var allowedTransitions = map[LoanStatus][]LoanStatus{
+	StatusDraft:     {StatusProposed, StatusCancelled},
+	StatusProposed:  {StatusSigned, StatusCancelled},
+	StatusSigned:    {StatusActive, StatusCancelled},
+	StatusActive:    {StatusRepaid, StatusOverdue, StatusDefaulted},
+	StatusOverdue:   {StatusRepaid, StatusDefaulted},
+	StatusDefaulted: {StatusRepaid},
+	StatusRepaid:    {},
+	StatusCancelled: {},
+}
+
+// signingStatuses - statuses only reached through borrower_sign and lender_sign
+var signingStatuses = map[LoanStatus]bool{StatusSigned: true, StatusActive: true}
+
+// outstandingStatuses - statuses of a loan with money still owed, see check_overdue
+var outstandingStatuses = map[LoanStatus]bool{StatusActive: true, StatusOverdue: true, StatusDefaulted: true}
+
+// initialStatuses - statuses a new Agreement may be created with
+var initialStatuses = []LoanStatus{StatusDraft, StatusProposed}
+
+type StatusChange struct { // One entry in the status history of an Agreement
+	From      LoanStatus `json:"from,omitempty"`
+	To        LoanStatus `json:"to"`
+	Actor     string     `json:"actor"`
+	Timestamp string     `json:"timestamp"`
+	TxID      string     `json:"tx_id"`
+}
+
+// ============================================================================================================================
+// parseStatus - accept a status in any letter case, reject anything outside the lifecycle
+// ============================================================================================================================
+func parseStatus(s string) (LoanStatus, error) {
+	status := LoanStatus(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := allowedTransitions[status]; !ok {
+		return "", invalidField("agreement_status", s, "Invalid agreement status, expecting draft, proposed, signed, active, overdue, repaid, defaulted or cancelled")
+	}
+	return status, nil
+}
+
+// ============================================================================================================================
+// currentStatus - status of a stored Agreement, records written before the lifecycle existed are treated as draft
+// ============================================================================================================================
+func currentStatus(res *Agreement) LoanStatus {
+	status, err := parseStatus(res.AgreementStatus)
+	if err != nil {
+		return StatusDraft
+	}
+	return status
+}
+
+// ============================================================================================================================
+// canTransition - check the lifecycle allows moving from one status to another
+// ============================================================================================================================
+func canTransition(from, to LoanStatus) bool {
+	for _, next := range allowedTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ============================================================================================================================
+// transition - move an Agreement to a new status and record who did it and when
+// ============================================================================================================================
+func transition(stub shim.ChaincodeStubInterface, res *Agreement, to LoanStatus) error {
+	from := currentStatus(res)
+	if !canTransition(from, to) {
+		return invalidTransition("agreement_status", "Agreement %s cannot move from %s to %s", res.AgreeementID, from, to)
+	}
+	if err := recordStatus(stub, res, from, to); err != nil {
+		return err
+	}
+	if len(allowedTransitions[to]) == 0 { //repaid or cancelled, its collateral is free again
+		return releaseAll(stub, res)
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// manualTransition - transition requested directly by a client, signing statuses are left to the parties and overdue and
+// repaid to check_overdue and record_repayment
+// ============================================================================================================================
+func manualTransition(stub shim.ChaincodeStubInterface, res *Agreement, to LoanStatus) error {
+	if signingStatuses[to] {
+		return invalidTransition("agreement_status", "Agreement %s becomes %s only when both parties have signed", res.AgreeementID, to)
+	}
+	if to == StatusOverdue {
+		return invalidTransition("agreement_status", "Agreement %s becomes overdue only through check_overdue", res.AgreeementID)
+	}
+	if to == StatusRepaid {
+		return invalidTransition("agreement_status", "Agreement %s becomes repaid only when record_repayment clears what is owed", res.AgreeementID)
+	}
+	return transition(stub, res, to)
+}
+
+// ============================================================================================================================
+// recordStatus - set the status and append the change to the status history
+// ============================================================================================================================
+func recordStatus(stub shim.ChaincodeStubInterface, res *Agreement, from, to LoanStatus) error {
+	caller, err := getCaller(stub)
+	if err != nil {
+		return err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	res.AgreementStatus = string(to)
+	res.StatusHistory = append(res.StatusHistory, StatusChange{
+		From:      from,
+		To:        to,
+		Actor:     caller.String(),
+		Timestamp: now.Format(time.RFC3339),
+		TxID:      stub.GetTxID(),
+	})
+	if from == "" { //a new Agreement, announced by AgreementCreated
+		return nil
+	}
+	return emitEvent(stub, EventStatusChanged, res.AgreeementID, from, to)
+}
+
+// ============================================================================================================================
+// update_status - move an Agreement to another lifecycle status
+// ============================================================================================================================
+func (t *ManageLoan) update_status(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting 2, or 3 with the expected version")
+	}
+	fmt.Println("start update_status")
+	agreement_id := args[0]
+	to, err := parseStatus(args[1])
+	if err != nil {
+		return nil, err
+	}
+	res, err := getAgreement(stub, agreement_id)
+	if err != nil {
+		return nil, err
+	}
+	if err = authorizeAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	if err = checkVersion(res, optionalArg(args, 2)); err != nil {
+		return nil, err
+	}
+	if err = manualTransition(stub, res, to); err != nil {
+		return nil, err
+	}
+	err = putAgreement(stub, res)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end update_status")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// isInitialStatus - check a new Agreement may be created with the given status
+// ============================================================================================================================
+func isInitialStatus(status LoanStatus) bool {
+	for _, s := range initialStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency - currency assumed for amounts given without a currency code
+const DefaultCurrency = "USD"
+
+// MaxRateBasisPoints - highest accepted annual interest rate, 1000%
+const MaxRateBasisPoints = 100000
+
+// currencyExponents - ISO 4217 currencies accepted on the ledger and their number of minor unit digits
+var currencyExponents = map[string]int{
+	"AED": 2, "AUD": 2, "BHD": 3, "BRL": 2, "CAD": 2, "CHF": 2, "CNY": 2, "DKK": 2,
+	"EUR": 2, "GBP": 2, "HKD": 2, "IDR": 2, "INR": 2, "JOD": 3, "JPY": 0, "KRW": 0,
+	"KWD": 3, "MXN": 2, "MYR": 2, "NOK": 2, "NZD": 2, "OMR": 3, "PHP": 2, "PLN": 2,
+	"SAR": 2, "SEK": 2, "SGD": 2, "THB": 2, "TRY": 2, "USD": 2, "VND": 0, "ZAR": 2,
+}
+
+// durationUnits - accepted loan duration units, plural forms map to the singular
+var durationUnits = map[string]string{
+	"day": "day", "days": "day",
+	"week": "week", "weeks": "week",
+	"month": "month", "months": "month",
+	"year": "year", "years": "year",
+}
+
+// MaxLoanMonths - longest loan term accepted, schedules grow with the term
+const MaxLoanMonths = 600
+
+// maxDuration - MaxLoanMonths counted in each duration unit
+var maxDuration = map[string]int{"day": 18262, "week": 2609, "month": MaxLoanMonths, "year": MaxLoanMonths / 12}
+
+type Money struct { // Amount as fixed-point minor units of an ISO 4217 currency
+	MinorUnits int64  `json:"minor_units"`
+	Currency   string `json:"currency"`
+	Raw        string `json:"raw,omitempty"` // schema version 1 text that could not be parsed, never set on new records
+}
+
+type Rate struct { // Annual interest rate in basis points, 1250 is 12.5%
+	BasisPoints int64  `json:"basis_points"`
+	Raw         string `json:"raw,omitempty"`     // schema version 1 text that could not be parsed, never set on new records
+	Missing     bool   `json:"missing,omitempty"` // schema version 1 record with no rate at all, Raw cannot tell it from a parsed one
+}
+
+type Duration struct { // Loan duration as a count of days, weeks, months or years
+	Value int    `json:"value"`
+	Unit  string `json:"unit"`
+	Raw   string `json:"raw,omitempty"` // schema version 1 text that could not be parsed, never set on new records
+}
+
+// ============================================================================================================================
+// ParseMoney - parse "10000.50 USD", "USD 10000.50" or "10000.50" (in the default currency) into a positive Money
+// ============================================================================================================================
+func ParseMoney(field, s string) (Money, error) {
+	fields := strings.Fields(s)
+	amount, currency := "", DefaultCurrency
+	switch len(fields) {
+	case 1:
+		amount = fields[0]
+	case 2:
+		amount, currency = fields[0], strings.ToUpper(fields[1])
+		if isDigits(strings.Replace(fields[1], ".", "", 1)) { //currency code written first
+			amount, currency = fields[1], strings.ToUpper(fields[0])
+		}
+	default:
+		return Money{}, invalidField(field, s, "expecting an amount and an optional ISO 4217 currency code")
+	}
+	exponent, ok := currencyExponents[currency]
+	if !ok {
+		return Money{}, invalidField(field, s, "unknown ISO 4217 currency code "+strconv.Quote(currency))
+	}
+	minor, err := parseMinorUnits(amount, exponent)
+	if err != nil {
+		return Money{}, invalidField(field, s, err.Error())
+	}
+	if minor <= 0 {
+		return Money{}, invalidField(field, s, "amount must be greater than zero")
+	}
+	return Money{MinorUnits: minor, Currency: currency}, nil
+}
+
+// ============================================================================================================================
+// parseMinorUnits - parse a plain decimal with at most exponent fraction digits into minor units
+// ============================================================================================================================
+func parseMinorUnits(s string, exponent int) (int64, error) {
+	whole, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if whole == "" || !isDigits(whole) || !isDigits(frac) {
+		return 0, fmt.Errorf("%q is not a decimal amount", s)
+	}
+	if len(frac) > exponent {
+		return 0, fmt.Errorf("%q has more than %d decimal places", s, exponent)
+	}
+	frac += strings.Repeat("0", exponent-len(frac))
+	minor, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is too large", s)
+	}
+	return minor, nil
+}
+
+// ============================================================================================================================
+// formatMinorUnits - format minor units as a plain decimal with exponent fraction digits
+// ============================================================================================================================
+func formatMinorUnits(minor int64, exponent int) string {
+	sign := ""
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	digits := strconv.FormatInt(minor, 10)
+	if exponent == 0 {
+		return sign + digits
+	}
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	return sign + digits[:len(digits)-exponent] + "." + digits[len(digits)-exponent:]
+}
+
+// isDigits - check a string holds only ASCII digits
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Amount - the same currency with a different number of minor units
+func (m Money) Amount(minor int64) Money {
+	return Money{MinorUnits: minor, Currency: m.Currency}
+}
+
+// Valid - false for legacy text that could not be parsed
+func (m Money) Valid() bool {
+	return m.Currency != ""
+}
+
+// String - the amount as a decimal followed by its currency code
+func (m Money) String() string {
+	if !m.Valid() {
+		return m.Raw
+	}
+	return formatMinorUnits(m.MinorUnits, currencyExponents[m.Currency]) + " " + m.Currency
+}
+
+// legacyMoney - Money from the plain string of a schema version 1 record, unparseable text is kept as Raw
+func legacyMoney(s string) Money {
+	m, err := ParseMoney("", s)
+	if err != nil {
+		return Money{Raw: s}
+	}
+	return m
+}
+
+// ============================================================================================================================
+// ParseRate - parse an annual percentage such as "12.5" or "12.5%" into basis points
+// ============================================================================================================================
+func ParseRate(field, s string) (Rate, error) {
+	text := strings.TrimSpace(s)
+	text = strings.TrimSpace(strings.TrimSuffix(text, "%"))
+	bps, err := parseMinorUnits(text, 2)
+	if err != nil {
+		return Rate{}, invalidField(field, s, "expecting a percentage with at most two decimal places")
+	}
+	if bps > MaxRateBasisPoints {
+		return Rate{}, invalidField(field, s, "rate is above 1000%")
+	}
+	return Rate{BasisPoints: bps}, nil
+}
+
+// Valid - false for legacy text that could not be parsed
+func (r Rate) Valid() bool {
+	return r.Raw == "" && !r.Missing
+}
+
+// String - the rate as a percentage
+func (r Rate) String() string {
+	if !r.Valid() {
+		return r.Raw
+	}
+	return formatMinorUnits(r.BasisPoints, 2) + "%"
+}
+
+// legacyRate - Rate from the plain string of a schema version 1 record, unparseable text is kept as Raw
+func legacyRate(s string) Rate {
+	if strings.TrimSpace(s) == "" {
+		return Rate{Raw: s, Missing: true}
+	}
+	r, err := ParseRate("", s)
+	if err != nil {
+		return Rate{Raw: s}
+	}
+	return r
+}
+
+// ============================================================================================================================
+// ParseDuration - parse "12", "12 months", "2 years", "90 days" or "26 weeks", a bare number counts months
+// ============================================================================================================================
+func ParseDuration(field, s string) (Duration, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 0 || len(fields) > 2 {
+		return Duration{}, invalidField(field, s, "expecting a count followed by day, week, month or year")
+	}
+	n, err := strconv.Atoi(fields[0])
+	if err != nil || n <= 0 {
+		return Duration{}, invalidField(field, s, "count must be a whole number greater than zero")
+	}
+	unit := "month"
+	if len(fields) == 2 {
+		if unit = durationUnits[fields[1]]; unit == "" {
+			return Duration{}, invalidField(field, s, "unit must be day, week, month or year")
+		}
+	}
+	if n > maxDuration[unit] {
+		return Duration{}, invalidField(field, s, fmt.Sprintf("at most %d %ss", maxDuration[unit], unit))
+	}
+	return Duration{Value: n, Unit: unit}, nil
+}
+
+// Months - the duration in whole months, false for day and week durations
+func (d Duration) Months() (int, bool) {
+	switch d.Unit {
+	case "month":
+		return d.Value, true
+	case "year":
+		return d.Value * 12, true
+	}
+	return 0, false
+}
+
+// Valid - false for legacy text that could not be parsed
+func (d Duration) Valid() bool {
+	return d.Unit != ""
+}
+
+// String - the count followed by its unit
+func (d Duration) String() string {
+	if !d.Valid() {
+		return d.Raw
+	}
+	return strconv.Itoa(d.Value) + " " + d.Unit
+}
+
+// legacyDuration - Duration from the plain string of a schema version 1 record, unparseable text is kept as Raw
+func legacyDuration(s string) Duration {
+	d, err := ParseDuration("", s)
+	if err != nil {
+		return Duration{Raw: s}
+	}
+	return d
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// OverduePolicyKey - state key of the OverduePolicy, see set_overdue_policy
+const OverduePolicyKey = "_OverduePolicy"
+
+type OverduePolicy struct { // How check_overdue treats loans past their repayment date
+	Document
+	PenaltyRate      Rate `json:"penalty_rate"`       // annual rate charged on the outstanding balance from the repayment date
+	GraceDays        int  `json:"grace_days"`         // days after the repayment date before a loan is overdue
+	DefaultAfterDays int  `json:"default_after_days"` // days a loan stays overdue before it is defaulted, 0 never defaults
+}
+
+// defaultOverduePolicy - used until set_overdue_policy is called
+var defaultOverduePolicy = OverduePolicy{Document: Document{SchemaVersion: SchemaVersion}, DefaultAfterDays: 90}
+
+var overduePolicyMigrations = map[int]migration{} //policies stored before schema_version have the same fields
+
+type Overdue struct { // Late payment state of an Agreement, set by check_overdue
+	Since           string `json:"since"` // date the loan was flagged overdue
+	DaysPastDue     int    `json:"days_past_due"`
+	PenaltyRate     Rate   `json:"penalty_rate"` // rate of the policy when the loan was flagged
+	PenaltyInterest Money  `json:"penalty_interest"`
+	PenaltyThrough  string `json:"penalty_through"`
+}
+
+type OverdueReport struct { // Result of a check_overdue run
+	AsOf      string   `json:"as_of"`
+	Checked   int      `json:"checked"`
+	Overdue   []string `json:"overdue"`   // flagged overdue by this run
+	Defaulted []string `json:"defaulted"` // defaulted by this run
+}
+
+// ============================================================================================================================
+// overduePolicy - the stored policy, or the default one
+// ============================================================================================================================
+func overduePolicy(stub shim.ChaincodeStubInterface) (OverduePolicy, error) {
+	policy := defaultOverduePolicy
+	policyAsBytes, err := stub.GetState(OverduePolicyKey)
+	if err != nil {
+		return policy, errors.New("Failed to get overdue policy")
+	}
+	if policyAsBytes != nil {
+		if err = decodeDocument(policyAsBytes, overduePolicyMigrations, &policy); err != nil {
+			return policy, fmt.Errorf("Failed to decode overdue policy: %s", err)
+		}
+	}
+	return policy, nil
+}
+
+// ============================================================================================================================
+// daysBetween - whole days from one date to another, negative when to comes first
+// ============================================================================================================================
+func daysBetween(from, to time.Time) int {
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// ============================================================================================================================
+// set_overdue_policy - store the penalty rate, grace period and days until default used by check_overdue
+// ============================================================================================================================
+func (t *ManageLoan) set_overdue_policy(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting penalty_rate, grace_days and default_after_days")
+	}
+	fmt.Println("start set_overdue_policy")
+	rate, err := ParseRate("penalty_rate", args[0])
+	if err != nil {
+		return nil, err
+	}
+	grace, err := strconv.Atoi(args[1])
+	if err != nil || grace < 0 {
+		return nil, invalidField("grace_days", args[1], "expecting a whole number of days")
+	}
+	defaultAfter, err := strconv.Atoi(args[2])
+	if err != nil || defaultAfter < 0 {
+		return nil, invalidField("default_after_days", args[2], "expecting a whole number of days, 0 never defaults")
+	}
+	policy := OverduePolicy{PenaltyRate: rate, GraceDays: grace, DefaultAfterDays: defaultAfter}
+	if err = putDocument(stub, OverduePolicyKey, &policy); err != nil {
+		return nil, err
+	}
+	fmt.Println("end set_overdue_policy")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// getOverduePolicy - the policy check_overdue applies
+// ============================================================================================================================
+func (t *ManageLoan) getOverduePolicy(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting none")
+	}
+	policy, err := overduePolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(policy)
+}
+
+// ============================================================================================================================
+// check_overdue - flag loans past their repayment date and grace period as overdue, charge penalty interest and default
+// the ones overdue for too long. Meant to be called by a scheduler, the transaction date decides what is late.
+// ============================================================================================================================
+func (t *ManageLoan) check_overdue(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 0 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting none")
+	}
+	fmt.Println("start check_overdue")
+	policy, err := overduePolicy(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	lastDue := today.AddDate(0, 0, -policy.GraceDays-1).Format(DateLayout) //due on this day or earlier and out of grace
+
+	iter, err := stub.GetStateByPartialCompositeKey(RepaymentDateIndex, []string{})
+	if err != nil {
+		return nil, errors.New("Failed to read index " + RepaymentDateIndex)
+	}
+	defer iter.Close()
+	var ids []string
+	for iter.HasNext() { //index keys sort by date, only the Agreements already due are read
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, parts, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		if parts[0] > lastDue {
+			break
+		}
+		ids = append(ids, parts[1])
+	}
+
+	report := OverdueReport{AsOf: today.Format(DateLayout), Overdue: []string{}, Defaulted: []string{}}
+	for _, agreement_id := range ids {
+		res, err := getAgreement(stub, agreement_id)
+		if err != nil {
+			return nil, err
+		}
+		if !outstandingStatuses[currentStatus(res)] || res.Archived != nil {
+			continue
+		}
+		report.Checked++
+		flagged, defaulted, err := applyOverdue(stub, res, policy, today)
+		if err != nil {
+			return nil, err
+		}
+		if flagged {
+			report.Overdue = append(report.Overdue, agreement_id)
+		}
+		if defaulted {
+			report.Defaulted = append(report.Defaulted, agreement_id)
+		}
+		if err = putAgreement(stub, res); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Println("end check_overdue")
+	return json.Marshal(report)
+}
+
+// ============================================================================================================================
+// applyOverdue - flag a loan past due as overdue, add penalty interest up to today and default it once overdue long enough
+// ============================================================================================================================
+func applyOverdue(stub shim.ChaincodeStubInterface, res *Agreement, policy OverduePolicy, today time.Time) (bool, bool, error) {
+	due, err := time.Parse(DateLayout, res.RepaymentDate)
+	if err != nil {
+		return false, false, invalidField("repayment_date", res.RepaymentDate, "expecting YYYY-MM-DD")
+	}
+	balance, err := outstandingBalance(res)
+	if err != nil {
+		return false, false, err
+	}
+	flagged, defaulted := false, false
+	if res.Overdue == nil {
+		res.Overdue = &Overdue{Since: today.Format(DateLayout), PenaltyRate: policy.PenaltyRate, PenaltyInterest: balance.Amount(0)}
+	}
+	if status := currentStatus(res); status == StatusActive {
+		if err = transition(stub, res, StatusOverdue); err != nil {
+			return false, false, err
+		}
+		if err = emitEvent(stub, EventAgreementOverdue, res.AgreeementID, status, StatusOverdue); err != nil {
+			return false, false, err
+		}
+		flagged = true
+	}
+	res.Overdue.DaysPastDue = daysBetween(due, today)
+
+	from := due //penalty runs from the repayment date, the grace period only delays it
+	if res.Overdue.PenaltyThrough != "" {
+		if from, err = time.Parse(DateLayout, res.Overdue.PenaltyThrough); err != nil {
+			return false, false, err
+		}
+	}
+	if today.After(from) {
+		dc, err := parseDayCount(res.DayCount)
+		if err != nil {
+			return false, false, err
+		}
+		days, yearDays := dayCountDays(dc, from, today)
+		penalty := accruedInterest(balance.MinorUnits, res.Overdue.PenaltyRate, days, yearDays, InterestSimple)
+		res.Overdue.PenaltyInterest = balance.Amount(res.Overdue.PenaltyInterest.MinorUnits + penalty)
+		res.Overdue.PenaltyThrough = today.Format(DateLayout)
+	}
+
+	since, err := time.Parse(DateLayout, res.Overdue.Since)
+	if err != nil {
+		return false, false, err
+	}
+	if currentStatus(res) == StatusOverdue && policy.DefaultAfterDays > 0 && daysBetween(since, today) >= policy.DefaultAfterDays {
+		if err = transition(stub, res, StatusDefaulted); err != nil {
+			return false, false, err
+		}
+		defaulted = true
+	}
+	return flagged, defaulted, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// expectReport - check which Agreements a check_overdue run flagged and defaulted
+func expectReport(checked int, overdue, defaulted []string) func(t *testing.T, c *testChain, payload []byte) {
+	return func(t *testing.T, c *testChain, payload []byte) {
+		var report OverdueReport
+		if err := json.Unmarshal(payload, &report); err != nil {
+			t.Fatalf("got %s", payload)
+		}
+		if report.Checked != checked || len(report.Overdue) != len(overdue) || len(report.Defaulted) != len(defaulted) {
+			t.Fatalf("got %+v", report)
+		}
+		for i := range overdue {
+			if report.Overdue[i] != overdue[i] {
+				t.Errorf("got %+v", report)
+			}
+		}
+		for i := range defaulted {
+			if report.Defaulted[i] != defaulted[i] {
+				t.Errorf("got %+v", report)
+			}
+		}
+	}
+}
+
+func TestCheckOverdue(t *testing.T) {
+	policy := func(c *testChain) { c.mustInvoke("admin", "set_overdue_policy", "24", "5", "30") }
+	overdue := func(c *testChain) {
+		policy(c)
+		at("2027-03-25")(c)
+		c.mustInvoke("servicer", "check_overdue")
+	}
+	runCases(t, []chainCase{
+		{name: "not yet due", setup: at("2027-03-15"), caller: "servicer", function: "check_overdue", check: expectReport(0, nil, nil)},
+		{name: "default policy, the day after", setup: at("2027-03-16"), caller: "servicer", function: "check_overdue",
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectReport(1, []string{"A3"}, nil)(t, c, payload)
+				res := c.agreement("A3")
+				if currentStatus(res) != StatusOverdue || res.Overdue == nil || res.Overdue.DaysPastDue != 1 || res.Overdue.PenaltyInterest.MinorUnits != 0 {
+					t.Errorf("got %+v", res)
+				}
+				ev := expectEvent(t, c, EventAgreementOverdue, "A3")
+				if ev.OldStatus != StatusActive || ev.NewStatus != StatusOverdue {
+					t.Errorf("got event %+v", ev)
+				}
+			}},
+		{name: "within the grace period", setup: func(c *testChain) { policy(c); at("2027-03-20")(c) },
+			caller: "servicer", function: "check_overdue", check: expectReport(0, nil, nil)},
+		{name: "past the grace period", setup: func(c *testChain) { policy(c); at("2027-03-25")(c) },
+			caller: "servicer", function: "check_overdue",
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectReport(1, []string{"A3"}, nil)(t, c, payload)
+				o := c.agreement("A3").Overdue
+				if o.Since != "2027-03-25" || o.DaysPastDue != 10 || o.PenaltyInterest.MinorUnits != 6575 || o.PenaltyRate.BasisPoints != 2400 {
+					t.Errorf("got %+v", o)
+				}
+			}},
+		{name: "penalty keeps running", setup: func(c *testChain) { overdue(c); at("2027-04-04")(c) },
+			caller: "admin", function: "check_overdue",
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectReport(1, nil, nil)(t, c, payload)
+				if o := c.agreement("A3").Overdue; o.PenaltyInterest.MinorUnits != 2*6575 || o.PenaltyThrough != "2027-04-04" {
+					t.Errorf("got %+v", o)
+				}
+			}},
+		{name: "defaults after the configured days", setup: func(c *testChain) { overdue(c); at("2027-04-24")(c) },
+			caller: "servicer", function: "check_overdue",
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectReport(1, nil, []string{"A3"})(t, c, payload)
+				expectStatus("A3", StatusDefaulted)(t, c, payload)
+				expectEvent(t, c, EventStatusChanged, "A3")
+			}},
+		{name: "repaid loans are left alone", setup: func(c *testChain) {
+			c.mustInvoke("bob", "record_repayment", "A3", "10000", "2027-03-01", "Alice", "r1")
+			at("2027-04-24")(c)
+		}, caller: "servicer", function: "check_overdue", check: expectReport(0, nil, nil)},
+		{name: "borrowers cannot", caller: "alice", function: "check_overdue", code: ErrForbidden},
+		{name: "penalty paid first", setup: overdue, caller: "bob", function: "record_repayment", args: []string{"A3", "50", "2027-03-25", "Alice", "r1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				res := c.agreement("A3")
+				if res.Overdue.PenaltyInterest.MinorUnits != 1575 || res.OutstandingBalance.MinorUnits != 1000000 || res.AccruedInterest.MinorUnits == 0 {
+					t.Errorf("got %+v", res)
+				}
+			}},
+		{name: "repaying an overdue loan", setup: overdue, caller: "bob", function: "record_repayment", args: []string{"A3", "10000", "2027-03-26", "Alice", "r1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				res := c.agreement("A3")
+				if currentStatus(res) != StatusOverdue || res.Overdue.PenaltyInterest.MinorUnits != 0 {
+					t.Errorf("penalty and interest come off first, got %+v", res)
+				}
+				c.mustInvoke("bob", "record_repayment", "A3", amountOwed(res, *res.OutstandingBalance).String(), "2027-03-26", "Alice", "r2")
+				expectStatus("A3", StatusRepaid)(t, c, payload)
+			}},
+		{name: "overdue is not set by hand", caller: "servicer", function: "update_status", args: []string{"A3", "overdue"}, code: ErrInvalidTransition},
+		{name: "overdue loans stay on the book", setup: overdue, caller: "servicer", function: "delete_po", args: []string{"A3", "closing"}, code: ErrInvalidTransition},
+	}, false)
+}
+
+func TestOverduePolicy(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "set", caller: "admin", function: "set_overdue_policy", args: []string{"18.5%", "3", "0"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				policy, err := overduePolicy(c.stub)
+				if err != nil || policy.PenaltyRate.BasisPoints != 1850 || policy.GraceDays != 3 || policy.DefaultAfterDays != 0 || policy.SchemaVersion != SchemaVersion {
+					t.Errorf("got %+v, %v", policy, err)
+				}
+			}},
+		{name: "negative grace", caller: "admin", function: "set_overdue_policy", args: []string{"18", "-1", "0"}, code: ErrInvalidArgument},
+		{name: "servicers cannot", caller: "servicer", function: "set_overdue_policy", args: []string{"18", "3", "0"}, code: ErrForbidden},
+	}, false)
+	runCases(t, []chainCase{
+		{name: "default", caller: "auditor", function: "getOverduePolicy",
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				if string(payload) != `{"schema_version":3,"penalty_rate":{"basis_points":0},"grace_days":0,"default_after_days":90}` {
+					t.Errorf("got %s", payload)
+				}
+			}},
+		{name: "stored without a schema version", caller: "auditor", function: "getOverduePolicy",
+			setup: func(c *testChain) {
+				c.stub.begin(nil)
+				c.stub.PutState(OverduePolicyKey, []byte(`{"penalty_rate":{"basis_points":2400},"grace_days":5,"default_after_days":30}`))
+				c.stub.commit()
+			},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var policy OverduePolicy
+				if err := json.Unmarshal(payload, &policy); err != nil || policy.PenaltyRate.BasisPoints != 2400 || policy.SchemaVersion != SchemaVersion {
+					t.Errorf("got %s", payload)
+				}
+			}},
+	}, true)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// DefaultPageSize / MaxPageSize - records returned per page when none or too many are asked for
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+type Page struct { // Response envelope of every query listing Agreements
+	Records  []*Agreement `json:"records"`
+	Bookmark string       `json:"bookmark"` // pass back to get the next page, empty on the last page
+	Count    int          `json:"count"`
+}
+
+type Paging struct { // Which page of a listing to return
+	PageSize        int32
+	Bookmark        string
+	IncludeArchived bool
+}
+
+// ============================================================================================================================
+// parsePaging - read the optional page size, bookmark and include archived arguments, an empty page size means the default
+// ============================================================================================================================
+func parsePaging(args []string) (Paging, error) {
+	if len(args) > 3 {
+		return Paging{}, invalidArgs("Incorrect number of arguments. Expecting an optional page size, bookmark and include archived flag")
+	}
+	pageSize := DefaultPageSize
+	if len(args) > 0 && args[0] != "" {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return Paging{}, invalidField("page_size", args[0], "expecting a whole number greater than zero")
+		}
+		pageSize = n
+	}
+	if pageSize > MaxPageSize {
+		pageSize = MaxPageSize
+	}
+	paging := Paging{PageSize: int32(pageSize)}
+	if len(args) > 1 {
+		paging.Bookmark = args[1]
+	}
+	if len(args) > 2 {
+		include, err := parseIncludeArchived(args[2])
+		if err != nil {
+			return Paging{}, err
+		}
+		paging.IncludeArchived = include
+	}
+	return paging, nil
+}
+
+// ============================================================================================================================
+// agreementPage - read one page of the Agreements listed under the given index attributes
+// ============================================================================================================================
+func agreementPage(stub shim.ChaincodeStubInterface, index string, attributes []string, paging Paging) ([]byte, error) {
+	iter, meta, err := stub.GetStateByPartialCompositeKeyWithPagination(index, attributes, paging.PageSize, paging.Bookmark)
+	if err != nil {
+		return nil, errors.New("Failed to read index " + index)
+	}
+	defer iter.Close()
+	page := Page{Records: []*Agreement{}}
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, parts, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		res, err := getAgreement(stub, parts[len(parts)-1])
+		if err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, res)
+	}
+	if meta != nil && len(page.Records) == int(paging.PageSize) { //a short page is the last one
+		page.Bookmark = meta.Bookmark
+	}
+	page.Records = unarchived(page.Records, paging.IncludeArchived)
+	if page.Records, err = visibleAgreements(stub, page.Records); err != nil {
+		return nil, err
+	}
+	page.Count = len(page.Records)
+	return json.Marshal(page)
+}
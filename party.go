@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// PartyObjectType - composite key prefix of registered parties, keyed by party id
+const PartyObjectType = "party"
+
+// PartyIdentityIndex - composite keys of the MSP ID, identity and id of every bound party
+const PartyIdentityIndex = "identity~party"
+
+// PartyType - legal form of a party
+type PartyType string
+
+const (
+	PartyIndividual   PartyType = "individual"
+	PartyOrganization PartyType = "organization"
+)
+
+// KYCStatus - outcome of the know-your-customer review of a party
+type KYCStatus string
+
+const (
+	KYCPending   KYCStatus = "pending"   // registered, not reviewed yet
+	KYCApproved  KYCStatus = "approved"  // may enter new Agreements
+	KYCRejected  KYCStatus = "rejected"  // failed the review
+	KYCSuspended KYCStatus = "suspended" // approved before, on hold
+)
+
+type Party struct { // A borrower or lender Agreements refer to by id
+	Document
+	PartyID     string    `json:"party_id"`
+	LegalName   string    `json:"legal_name"`
+	Type        PartyType `json:"type"`
+	MSPID       string    `json:"msp_id"`   // organization of the identity acting for the party
+	Identity    string    `json:"identity"` // common name of the enrollment certificate acting for the party
+	KYCStatus   KYCStatus `json:"kyc_status"`
+	KYCUpdated  string    `json:"kyc_updated,omitempty"`
+	ContactHash string    `json:"contact_hash"` // hex SHA-256 of the contact details, kept off the ledger
+}
+
+var partyMigrations = map[int]migration{} //parties were added at schema version 3
+
+// partyFields - fields update_party may change, keyed by their JSON name
+var partyFields = map[string]func(p *Party, value string) error{
+	"legal_name": func(p *Party, value string) error {
+		p.LegalName = strings.TrimSpace(value)
+		if p.LegalName == "" {
+			return invalidField("legal_name", value, "must not be empty")
+		}
+		return nil
+	},
+	"type": func(p *Party, value string) error {
+		pt, err := parsePartyType(value)
+		p.Type = pt
+		return err
+	},
+	"msp_id": func(p *Party, value string) error {
+		if value == "" {
+			return invalidField("msp_id", value, "must not be empty")
+		}
+		p.MSPID = value
+		return nil
+	},
+	"identity": func(p *Party, value string) error {
+		if value == "" {
+			return invalidField("identity", value, "must not be empty")
+		}
+		p.Identity = value
+		return nil
+	},
+	"kyc_status": func(p *Party, value string) error {
+		status, err := parseKYCStatus(value)
+		p.KYCStatus = status
+		return err
+	},
+	"contact_hash": func(p *Party, value string) error {
+		hash, err := parseContactHash(value)
+		p.ContactHash = hash
+		return err
+	},
+}
+
+// ============================================================================================================================
+// parsePartyType / parseKYCStatus / parseContactHash - validate party fields, in any letter case
+// ============================================================================================================================
+func parsePartyType(s string) (PartyType, error) {
+	switch pt := PartyType(strings.ToLower(strings.TrimSpace(s))); pt {
+	case PartyIndividual, PartyOrganization:
+		return pt, nil
+	}
+	return "", invalidField("type", s, "expecting individual or organization")
+}
+
+func parseKYCStatus(s string) (KYCStatus, error) {
+	switch status := KYCStatus(strings.ToLower(strings.TrimSpace(s))); status {
+	case KYCPending, KYCApproved, KYCRejected, KYCSuspended:
+		return status, nil
+	}
+	return "", invalidField("kyc_status", s, "expecting pending, approved, rejected or suspended")
+}
+
+func parseContactHash(s string) (string, error) {
+	hash := strings.ToLower(strings.TrimSpace(s))
+	if raw, err := hex.DecodeString(hash); err != nil || len(raw) != 32 {
+		return "", invalidField("contact_hash", s, "expecting a hex SHA-256 digest")
+	}
+	return hash, nil
+}
+
+// ============================================================================================================================
+// partyKey - state key of a party
+// ============================================================================================================================
+func partyKey(stub shim.ChaincodeStubInterface, party_id string) (string, error) {
+	if party_id == "" {
+		return "", invalidField("party_id", party_id, "must not be empty")
+	}
+	return stub.CreateCompositeKey(PartyObjectType, []string{party_id})
+}
+
+// ============================================================================================================================
+// getParty - read a registered party, error when it does not exist
+// ============================================================================================================================
+func getParty(stub shim.ChaincodeStubInterface, party_id string) (*Party, error) {
+	key, err := partyKey(stub, party_id)
+	if err != nil {
+		return nil, err
+	}
+	partyAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state for party " + party_id)
+	}
+	if partyAsBytes == nil {
+		return nil, notFound("party_id", party_id, "Party not found: "+party_id)
+	}
+	p := Party{}
+	if err = decodeDocument(partyAsBytes, partyMigrations, &p); err != nil {
+		return nil, fmt.Errorf("Failed to decode party %s: %s", party_id, err)
+	}
+	return &p, nil
+}
+
+// ============================================================================================================================
+// putParty - store a party and move its identity binding from its previous version (nil when new)
+// ============================================================================================================================
+func putParty(stub shim.ChaincodeStubInterface, old, p *Party) error {
+	bound, err := partyByIdentity(stub, p.MSPID, p.Identity)
+	if err != nil {
+		return err
+	}
+	if bound != "" && bound != p.PartyID {
+		return alreadyExists("identity", p.MSPID+"/"+p.Identity, "This identity already acts for party "+bound)
+	}
+	key, err := partyKey(stub, p.PartyID)
+	if err != nil {
+		return err
+	}
+	if err = putDocument(stub, key, p); err != nil {
+		return err
+	}
+	if old != nil && (old.MSPID != p.MSPID || old.Identity != p.Identity) {
+		oldKey, err := stub.CreateCompositeKey(PartyIdentityIndex, []string{old.MSPID, old.Identity, old.PartyID})
+		if err != nil {
+			return err
+		}
+		if err = stub.DelState(oldKey); err != nil {
+			return err
+		}
+	}
+	bindingKey, err := stub.CreateCompositeKey(PartyIdentityIndex, []string{p.MSPID, p.Identity, p.PartyID})
+	if err != nil {
+		return err
+	}
+	return stub.PutState(bindingKey, indexValue)
+}
+
+// ============================================================================================================================
+// partyByIdentity - id of the party an identity is bound to, empty when it is bound to none
+// ============================================================================================================================
+func partyByIdentity(stub shim.ChaincodeStubInterface, msp_id, identity string) (string, error) {
+	iter, err := stub.GetStateByPartialCompositeKey(PartyIdentityIndex, []string{msp_id, identity})
+	if err != nil {
+		return "", errors.New("Failed to read index " + PartyIdentityIndex)
+	}
+	defer iter.Close()
+	if !iter.HasNext() {
+		return "", nil
+	}
+	kv, err := iter.Next()
+	if err != nil {
+		return "", err
+	}
+	_, parts, err := stub.SplitCompositeKey(kv.Key)
+	if err != nil {
+		return "", err
+	}
+	return parts[2], nil
+}
+
+// ============================================================================================================================
+// resolveParty - the party a caller acts for, the one registered to its MSP ID and common name, empty when there is none.
+// A name alone is never enough, the same common name may be issued by any MSP.
+// ============================================================================================================================
+func resolveParty(stub shim.ChaincodeStubInterface, caller Caller) (string, error) {
+	return partyByIdentity(stub, caller.MSPID, caller.Name)
+}
+
+// ============================================================================================================================
+// bindParties - check both parties of an Agreement are registered and KYC approved and copy their legal names onto it
+// ============================================================================================================================
+func bindParties(stub shim.ChaincodeStubInterface, res *Agreement) error {
+	borrower, err := approvedParty(stub, "borrower_id", res.BorrowerID)
+	if err != nil {
+		return err
+	}
+	lender, err := approvedParty(stub, "lender_id", res.LenderID)
+	if err != nil {
+		return err
+	}
+	res.BorrowerName, res.LenderName = borrower.LegalName, lender.LegalName
+	return nil
+}
+
+// approvedParty - a registered party that passed KYC, field names the Agreement field referring to it
+func approvedParty(stub shim.ChaincodeStubInterface, field, party_id string) (*Party, error) {
+	if party_id == "" {
+		return nil, invalidField(field, party_id, "must not be empty")
+	}
+	p, err := getParty(stub, party_id)
+	if err != nil {
+		return nil, err
+	}
+	if p.KYCStatus != KYCApproved {
+		return nil, invalidField(field, party_id, fmt.Sprintf("KYC of party %s is %s, parties must be approved", party_id, p.KYCStatus))
+	}
+	return p, nil
+}
+
+// ============================================================================================================================
+// register_party - record a borrower or lender with the identity acting for it, KYC starts pending
+// ============================================================================================================================
+func (t *ManageLoan) register_party(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 6 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting party_id, legal_name, type, msp_id, identity and contact_hash")
+	}
+	fmt.Println("start register_party")
+	party_id := args[0]
+	key, err := partyKey(stub, party_id)
+	if err != nil {
+		return nil, err
+	}
+	p := Party{PartyID: party_id, KYCStatus: KYCPending}
+	for i, name := range []string{"legal_name", "type", "msp_id", "identity", "contact_hash"} {
+		if err = partyFields[name](&p, args[i+1]); err != nil {
+			return nil, err
+		}
+	}
+	partyAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("Failed to get state for party " + party_id)
+	}
+	if partyAsBytes != nil {
+		return nil, alreadyExists("party_id", party_id, "This party already exists")
+	}
+	if err = putParty(stub, nil, &p); err != nil {
+		return nil, err
+	}
+	fmt.Println("end register_party")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// update_party - change only the fields of a party present in a JSON patch, KYC reviews set kyc_status
+// ============================================================================================================================
+func (t *ManageLoan) update_party(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting party_id and a JSON patch")
+	}
+	fmt.Println("start update_party")
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(args[1]), &patch); err != nil {
+		return nil, invalidField("patch", args[1], "expecting a JSON object of the fields to change")
+	}
+	if len(patch) == 0 {
+		return nil, invalidField("patch", args[1], "no fields to change")
+	}
+	old, err := getParty(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	p := *old
+	names := make([]string, 0, len(patch))
+	for name := range patch {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		apply, ok := partyFields[name]
+		if !ok {
+			return nil, invalidField(name, string(patch[name]), "unknown or read-only field")
+		}
+		var value *string
+		if err = json.Unmarshal(patch[name], &value); err != nil {
+			return nil, invalidField(name, string(patch[name]), "expecting a string or null")
+		}
+		if value == nil {
+			value = new(string)
+		}
+		if err = apply(&p, *value); err != nil {
+			return nil, err
+		}
+	}
+	if p.KYCStatus != old.KYCStatus {
+		now, err := txTime(stub)
+		if err != nil {
+			return nil, err
+		}
+		p.KYCUpdated = now.Format(time.RFC3339)
+	}
+	if err = putParty(stub, old, &p); err != nil {
+		return nil, err
+	}
+	fmt.Println("end update_party")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// getParty_byID - a registered party, any client may look up the counterparty of an Agreement
+// ============================================================================================================================
+func (t *ManageLoan) getParty_byID(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting party_id")
+	}
+	fmt.Println("start getParty_byID")
+	p, err := getParty(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end getParty_byID")
+	return json.Marshal(p)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// expectParty - check the registered state of a party
+func expectParty(party_id, legalName string, kyc KYCStatus) func(t *testing.T, c *testChain, payload []byte) {
+	return func(t *testing.T, c *testChain, payload []byte) {
+		p, err := getParty(c.stub, party_id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.LegalName != legalName || p.KYCStatus != kyc {
+			t.Errorf("got %+v", p)
+		}
+	}
+}
+
+// dave - register Dave, bound to a new identity, with KYC pending
+func dave(c *testChain) {
+	c.ids["dave"] = newIdentity(c.t, "Org1MSP", "dave", "borrower")
+	c.mustInvoke("servicer", "register_party", "Dave", "Dave Lee", "individual", "Org1MSP", "dave", contactHash)
+}
+
+func TestRegisterParty(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "register", caller: "servicer", function: "register_party", args: []string{"Dave", " Dave Lee ", "Individual", "Org1MSP", "dave", contactHash},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectParty("Dave", "Dave Lee", KYCPending)(t, c, payload)
+				if id, err := partyByIdentity(c.stub, "Org1MSP", "dave"); err != nil || id != "Dave" {
+					t.Errorf("bound to %q, %v", id, err)
+				}
+			}},
+		{name: "duplicate id", caller: "admin", function: "register_party", args: []string{"Alice", "Alice Moreau", "individual", "Org1MSP", "alice2", contactHash},
+			code: ErrAlreadyExists},
+		{name: "identity already bound", caller: "admin", function: "register_party", args: []string{"Dave", "Dave Lee", "individual", "Org1MSP", "alice", contactHash},
+			code: ErrAlreadyExists},
+		{name: "unknown type", caller: "admin", function: "register_party", args: []string{"Dave", "Dave Lee", "trust", "Org1MSP", "dave", contactHash},
+			code: ErrInvalidArgument},
+		{name: "contact in the clear", caller: "admin", function: "register_party", args: []string{"Dave", "Dave Lee", "individual", "Org1MSP", "dave", "dave@example.com"},
+			code: ErrInvalidArgument},
+		{name: "borrowers cannot", caller: "alice", function: "register_party", args: []string{"Dave", "Dave Lee", "individual", "Org1MSP", "dave", contactHash},
+			code: ErrForbidden},
+	}, false)
+}
+
+func TestUpdateParty(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "approve", setup: dave, caller: "servicer", function: "update_party", args: []string{"Dave", `{"kyc_status":"Approved"}`},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectParty("Dave", "Dave Lee", KYCApproved)(t, c, payload)
+				if p, _ := getParty(c.stub, "Dave"); p.KYCUpdated == "" {
+					t.Errorf("got %+v", p)
+				}
+			}},
+		{name: "rename", caller: "admin", function: "update_party", args: []string{"Bob", `{"legal_name":"Bob Capital plc"}`},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectParty("Bob", "Bob Capital plc", KYCApproved)(t, c, payload)
+				if res := c.agreement("A3"); res.LenderName != "Bob Capital Ltd" {
+					t.Errorf("the Agreement keeps the name it was signed under, got %+v", res)
+				}
+			}},
+		{name: "rebind", caller: "admin", function: "update_party", args: []string{"Alice", `{"identity":"alice.moreau"}`},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				if id, _ := partyByIdentity(c.stub, "Org1MSP", "alice"); id != "" {
+					t.Errorf("old binding left behind for %q", id)
+				}
+				if id, _ := partyByIdentity(c.stub, "Org1MSP", "alice.moreau"); id != "Alice" {
+					t.Errorf("bound to %q", id)
+				}
+			}},
+		{name: "rebind to a taken identity", caller: "admin", function: "update_party", args: []string{"Alice", `{"identity":"carol"}`}, code: ErrAlreadyExists},
+		{name: "unknown KYC status", caller: "servicer", function: "update_party", args: []string{"Alice", `{"kyc_status":"ok"}`}, code: ErrInvalidArgument},
+		{name: "read-only field", caller: "servicer", function: "update_party", args: []string{"Alice", `{"party_id":"Alicia"}`}, code: ErrInvalidArgument},
+		{name: "unknown party", caller: "servicer", function: "update_party", args: []string{"Dave", `{"kyc_status":"approved"}`}, code: ErrNotFound},
+		{name: "lenders cannot", caller: "bob", function: "update_party", args: []string{"Bob", `{"kyc_status":"approved"}`}, code: ErrForbidden},
+	}, false)
+}
+
+func TestAgreementParties(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "legal names copied", caller: "alice", function: "create_agreement", args: terms("B1", "draft", "", ""),
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				if res := c.agreement("B1"); res.BorrowerID != "Alice" || res.BorrowerName != "Alice Moreau" || res.LenderName != "Bob Capital Ltd" {
+					t.Errorf("got %+v", res)
+				}
+			}},
+		{name: "unregistered lender", caller: "alice", function: "create_agreement",
+			args: []string{"B1", "Alice", "Eve", "2026-01-15", "10000 USD", "draft", "12", "12", "", "", "", ""}, code: ErrNotFound},
+		{name: "borrower KYC pending", setup: dave, caller: "dave", function: "create_agreement",
+			args: []string{"B1", "Dave", "Bob", "2026-01-15", "10000 USD", "draft", "12", "12", "", "", "", ""}, code: ErrInvalidArgument},
+		{name: "lender KYC suspended", caller: "alice", function: "create_agreement", args: terms("B1", "draft", "", ""),
+			setup: func(c *testChain) { c.mustInvoke("servicer", "update_party", "Bob", `{"kyc_status":"suspended"}`) },
+			code:  ErrInvalidArgument},
+		{name: "suspension leaves signed loans alone", caller: "bob", function: "record_repayment", args: []string{"A3", "100", "2026-02-15", "Alice", "r1"},
+			setup: func(c *testChain) { c.mustInvoke("servicer", "update_party", "Bob", `{"kyc_status":"suspended"}`) }},
+		{name: "patching in an unapproved party", setup: dave, caller: "bob", function: "update_agreement", args: []string{"A1", `{"borrower_id":"Dave"}`},
+			code: ErrInvalidArgument},
+		{name: "patching in another approved party", caller: "servicer", function: "update_agreement", args: []string{"A1", `{"borrower_id":"Carol"}`},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				if res := c.agreement("A1"); res.BorrowerID != "Carol" || res.BorrowerName != "Carol Dupont" {
+					t.Errorf("got %+v", res)
+				}
+			}},
+		{name: "a party's name is not enough", caller: "eve", function: "borrower_sign", args: []string{"A2"},
+			setup: func(c *testChain) { c.ids["eve"] = newIdentity(c.t, "Org1MSP", "Alice", "borrower") },
+			code:  ErrForbidden},
+		{name: "the bound name from a foreign MSP", caller: "alice3", function: "borrower_sign", args: []string{"A2"},
+			setup: func(c *testChain) { c.ids["alice3"] = newIdentity(c.t, "Org3MSP", "alice", "borrower") },
+			code:  ErrForbidden},
+		{name: "the bound identity acts for its party", caller: "bob2", function: "lender_sign", args: []string{"A2"},
+			setup: func(c *testChain) {
+				c.mustInvoke("admin", "update_party", "Bob", `{"identity":"bob2"}`)
+				c.ids["bob2"] = newIdentity(c.t, "Org2MSP", "bob2", "lender")
+			}},
+	}, false)
+	runCases(t, []chainCase{
+		{name: "read a party", caller: "carol", function: "getParty_byID", args: []string{"Bob"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var p Party
+				if err := json.Unmarshal(payload, &p); err != nil || p.Type != PartyOrganization || p.MSPID != "Org2MSP" || p.ContactHash != contactHash {
+					t.Errorf("got %s", payload)
+				}
+			}},
+		{name: "unknown party", caller: "carol", function: "getParty_byID", args: []string{"Eve"}, code: ErrNotFound},
+		{name: "by lender id", caller: "bob", function: "getAgreement_byBuyer", args: []string{"Bob"}, check: expectPage("A1", "A2", "A3")},
+		{name: "by lender name", caller: "servicer", function: "getAgreement_byBuyer", args: []string{"Bob Capital Ltd"}, check: expectPage()},
+	}, true)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+type patchField struct { // A field update_agreement may change
+	locked bool // part of the terms, frozen once the Agreement leaves draft
+	apply  func(res *Agreement, value string) error
+}
+
+// patchFields - keyed by the JSON name of the field, the status changes through update_status only
+var patchFields = map[string]patchField{
+	"borrower_id": {locked: true, apply: func(res *Agreement, value string) error {
+		res.BorrowerID = value //checked against the party registry once the patch is applied
+		return nil
+	}},
+	"lender_id": {locked: true, apply: func(res *Agreement, value string) error {
+		res.LenderID = value
+		return nil
+	}},
+	"agreement_date": {locked: true, apply: func(res *Agreement, value string) error {
+		res.AgreementDate = value
+		return nil
+	}},
+	"loan_amount": {locked: true, apply: func(res *Agreement, value string) error {
+		amount, err := ParseMoney("loan_amount", value)
+		res.LoanAmount = amount
+		return err
+	}},
+	"interest_rate": {locked: true, apply: func(res *Agreement, value string) error {
+		rate, err := ParseRate("interest_rate", value)
+		res.InterestRate = rate
+		return err
+	}},
+	"loan_duration": {locked: true, apply: func(res *Agreement, value string) error {
+		duration, err := ParseDuration("loan_duration", value)
+		res.LoanDuration = duration
+		return err
+	}},
+	"repayment_type": {locked: true, apply: func(res *Agreement, value string) error {
+		rt, err := parseRepaymentType(value)
+		res.RepaymentType = string(rt)
+		return err
+	}},
+	"day_count": {locked: true, apply: func(res *Agreement, value string) error {
+		dc, err := parseDayCount(value)
+		res.DayCount = string(dc)
+		return err
+	}},
+	"interest_method": {locked: true, apply: func(res *Agreement, value string) error {
+		method, err := parseInterestMethod(value)
+		res.InterestMethod = string(method)
+		return err
+	}},
+	"repayment_date": {locked: true, apply: func(res *Agreement, value string) error {
+		if value != "" {
+			if _, err := time.Parse(DateLayout, value); err != nil {
+				return invalidField("repayment_date", value, "expecting YYYY-MM-DD")
+			}
+		}
+		res.RepaymentDate = value
+		return nil
+	}},
+	"comments": {apply: func(res *Agreement, value string) error {
+		res.Comments = value
+		return nil
+	}},
+}
+
+// ============================================================================================================================
+// update_agreement - change only the fields present in a JSON patch, null clears a field
+// ============================================================================================================================
+func (t *ManageLoan) update_agreement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id, a JSON patch and an optional expected version")
+	}
+	fmt.Println("start update_agreement")
+	agreement_id := args[0]
+	var patch map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(args[1]), &patch); err != nil {
+		return nil, invalidField("patch", args[1], "expecting a JSON object of the fields to change")
+	}
+	if len(patch) == 0 {
+		return nil, invalidField("patch", args[1], "no fields to change")
+	}
+	res, err := getAgreement(stub, agreement_id)
+	if err != nil {
+		return nil, err
+	}
+	if err = authorizeAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	if err = checkVersion(res, optionalArg(args, 2)); err != nil {
+		return nil, err
+	}
+	if awaitingSignatures(res) { //terms out for signing can only be cancelled, as in update_po
+		return nil, invalidTransition("agreement_status", "Agreement %s is awaiting signatures and can only be cancelled", agreement_id)
+	}
+
+	status := currentStatus(res)
+	borrower_id, lender_id := res.BorrowerID, res.LenderID
+	names := make([]string, 0, len(patch))
+	for name := range patch {
+		names = append(names, name)
+	}
+	sort.Strings(names) //report the same error whatever order the client sent
+	for _, name := range names {
+		field, ok := patchFields[name]
+		if !ok {
+			return nil, invalidField(name, string(patch[name]), "unknown or read-only field, statuses change through update_status")
+		}
+		if field.locked && status != StatusDraft {
+			return nil, invalidField(name, string(patch[name]), fmt.Sprintf("locked while the Agreement is %s, terms only change in draft", status))
+		}
+		var value *string
+		if err = json.Unmarshal(patch[name], &value); err != nil {
+			return nil, invalidField(name, string(patch[name]), "expecting a string or null")
+		}
+		if value == nil {
+			value = new(string)
+		}
+		if err = field.apply(res, *value); err != nil {
+			return nil, err
+		}
+	}
+	if res.BorrowerID != borrower_id || res.LenderID != lender_id {
+		if err = bindParties(stub, res); err != nil {
+			return nil, err
+		}
+	}
+	if err = authorizeAgreement(stub, res); err != nil { //a party may not hand the Agreement over to others
+		return nil, err
+	}
+
+	if err = putAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	if err = emitEvent(stub, EventAgreementUpdated, agreement_id, status, status); err != nil {
+		return nil, err
+	}
+	fmt.Println("end update_agreement")
+	return nil, nil
+}
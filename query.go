@@ -0,0 +1,478 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// AgreementQuery - argument of query_agreements, e.g.
+//
+//	{"filter": {"and": [{"field": "status", "op": "eq", "value": "active"},
+//	                    {"field": "loan_amount", "op": "gt", "value": "50000 USD"},
+//	                    {"field": "repayment_date", "op": "lt", "value": "2025-03-01"}]},
+//	 "sort": {"field": "repayment_date", "order": "asc"}}
+type AgreementQuery struct {
+	Filter *Filter     `json:"filter"`
+	Sort   *SortOption `json:"sort,omitempty"`
+}
+
+type Filter struct { // Either a comparison (field, op, value) or a list of filters combined with and/or
+	And   []Filter        `json:"and,omitempty"`
+	Or    []Filter        `json:"or,omitempty"`
+	Field string          `json:"field,omitempty"`
+	Op    string          `json:"op,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type SortOption struct {
+	Field string `json:"field"`
+	Order string `json:"order,omitempty"` // asc (default) or desc
+}
+
+type fieldKind int
+
+const (
+	kindText fieldKind = iota
+	kindDate
+	kindMoney
+	kindRate
+)
+
+type queryField struct { // A filterable Agreement field, compared the same way by CouchDB and by the index scan
+	kind   fieldKind
+	path   string                               // CouchDB path of the compared value
+	text   func(res *Agreement) string          // text and date fields
+	number func(res *Agreement) (int64, string) // minor units and currency, or basis points
+}
+
+// queryFields - fields query_agreements can filter and sort on
+var queryFields = map[string]queryField{
+	"agreement_id":   {kind: kindText, path: "agreement_id", text: func(r *Agreement) string { return r.AgreeementID }},
+	"borrower_id":    {kind: kindText, path: "borrower_id", text: func(r *Agreement) string { return r.BorrowerID }},
+	"lender_id":      {kind: kindText, path: "lender_id", text: func(r *Agreement) string { return r.LenderID }},
+	"borrower_name":  {kind: kindText, path: "borrower_name", text: func(r *Agreement) string { return r.BorrowerName }},
+	"lender_name":    {kind: kindText, path: "lender_name", text: func(r *Agreement) string { return r.LenderName }},
+	"status":         {kind: kindText, path: "agreement_status", text: func(r *Agreement) string { return string(currentStatus(r)) }},
+	"repayment_type": {kind: kindText, path: "repayment_type", text: func(r *Agreement) string { return r.RepaymentType }},
+	"currency":       {kind: kindText, path: "loan_amount.currency", text: func(r *Agreement) string { return r.LoanAmount.Currency }},
+	"agreement_date": {kind: kindDate, path: "agreement_date", text: func(r *Agreement) string { return r.AgreementDate }},
+	"repayment_date": {kind: kindDate, path: "repayment_date", text: func(r *Agreement) string { return r.RepaymentDate }},
+	"loan_amount": {kind: kindMoney, path: "loan_amount", number: func(r *Agreement) (int64, string) {
+		return r.LoanAmount.MinorUnits, r.LoanAmount.Currency
+	}},
+	"interest_rate": {kind: kindRate, path: "interest_rate.basis_points", number: func(r *Agreement) (int64, string) {
+		return r.InterestRate.BasisPoints, ""
+	}},
+}
+
+// couchOperators - comparison operators and their CouchDB selector form
+var couchOperators = map[string]string{"eq": "$eq", "ne": "$ne", "gt": "$gt", "gte": "$gte", "lt": "$lt", "lte": "$lte"}
+
+type condition struct { // A compiled Filter with its operand parsed for the field's kind
+	and, or  []*condition
+	name     string
+	field    queryField
+	op       string
+	text     string
+	number   int64
+	currency string
+}
+
+// ============================================================================================================================
+// compileFilter - validate a Filter and parse its operands
+// ============================================================================================================================
+func compileFilter(f *Filter) (*condition, error) {
+	if len(f.And) > 0 || len(f.Or) > 0 {
+		if f.Field != "" || (len(f.And) > 0 && len(f.Or) > 0) {
+			return nil, invalidField("filter", "", "a filter is either a comparison, an and list or an or list")
+		}
+		c := &condition{}
+		for i := range f.And {
+			sub, err := compileFilter(&f.And[i])
+			if err != nil {
+				return nil, err
+			}
+			c.and = append(c.and, sub)
+		}
+		for i := range f.Or {
+			sub, err := compileFilter(&f.Or[i])
+			if err != nil {
+				return nil, err
+			}
+			c.or = append(c.or, sub)
+		}
+		return c, nil
+	}
+
+	field, ok := queryFields[f.Field]
+	if !ok {
+		return nil, invalidField("filter.field", f.Field, "unknown or unfilterable field")
+	}
+	if _, ok := couchOperators[f.Op]; !ok {
+		return nil, invalidField("filter.op", f.Op, "expecting eq, ne, gt, gte, lt or lte")
+	}
+	var value string
+	if err := json.Unmarshal(f.Value, &value); err != nil {
+		value = strings.TrimSpace(string(f.Value)) //bare JSON numbers are fine too
+	}
+	c := &condition{name: f.Field, field: field, op: f.Op}
+	switch field.kind {
+	case kindText:
+		c.text = value
+		if f.Field == "status" {
+			status, err := parseStatus(value)
+			if err != nil {
+				return nil, invalidField("filter.value", value, err.Error())
+			}
+			c.text = string(status)
+		}
+	case kindDate:
+		if _, err := time.Parse(DateLayout, value); err != nil {
+			return nil, invalidField("filter.value", value, "expecting YYYY-MM-DD")
+		}
+		c.text = value
+	case kindMoney:
+		m, err := ParseMoney("filter.value", value)
+		if err != nil {
+			return nil, err
+		}
+		c.number, c.currency = m.MinorUnits, m.Currency
+	case kindRate:
+		r, err := ParseRate("filter.value", value)
+		if err != nil {
+			return nil, err
+		}
+		c.number = r.BasisPoints
+	}
+	return c, nil
+}
+
+// ============================================================================================================================
+// selector - the condition as a CouchDB selector
+// ============================================================================================================================
+func (c *condition) selector() map[string]interface{} {
+	if c.and != nil || c.or != nil {
+		op, subs := "$and", c.and
+		if c.or != nil {
+			op, subs = "$or", c.or
+		}
+		list := []interface{}{}
+		for _, sub := range subs {
+			list = append(list, sub.selector())
+		}
+		return map[string]interface{}{op: list}
+	}
+	couchOp := couchOperators[c.op]
+	switch c.field.kind {
+	case kindMoney: //amounts only compare within their currency
+		amount := map[string]interface{}{c.field.path + ".minor_units": map[string]interface{}{couchOp: c.number}}
+		currency := map[string]interface{}{c.field.path + ".currency": c.currency}
+		if c.op == "ne" {
+			return map[string]interface{}{"$or": []interface{}{
+				map[string]interface{}{c.field.path + ".currency": map[string]interface{}{"$ne": c.currency}}, amount}}
+		}
+		return map[string]interface{}{"$and": []interface{}{currency, amount}}
+	case kindRate:
+		return map[string]interface{}{c.field.path: map[string]interface{}{couchOp: c.number}}
+	}
+	if c.name == "status" {
+		return c.statusSelector()
+	}
+	return map[string]interface{}{c.field.path: map[string]interface{}{couchOp: c.text}}
+}
+
+// ============================================================================================================================
+// statusSelector - the statuses the comparison admits, matched the way currentStatus reads them. Legacy records that
+// were never rewritten store them in any letter case, or store none and count as draft.
+// ============================================================================================================================
+func (c *condition) statusSelector() map[string]interface{} {
+	admitted := []string{}
+	for status := range allowedTransitions {
+		probe := &Agreement{AgreementStatus: string(status)}
+		if c.match(probe) {
+			admitted = append(admitted, string(status))
+		}
+	}
+	if len(admitted) == 0 {
+		return map[string]interface{}{c.field.path: map[string]interface{}{"$in": []interface{}{}}}
+	}
+	sort.Strings(admitted)
+	alternatives := strings.Join(admitted, "|")
+	if c.match(&Agreement{}) {
+		alternatives += "|"
+	}
+	return map[string]interface{}{c.field.path: map[string]interface{}{"$regex": "(?i)^\\s*(" + alternatives + ")\\s*$"}}
+}
+
+// ============================================================================================================================
+// match - evaluate the condition against an Agreement the same way the selector does
+// ============================================================================================================================
+func (c *condition) match(res *Agreement) bool {
+	if c.and != nil {
+		for _, sub := range c.and {
+			if !sub.match(res) {
+				return false
+			}
+		}
+		return true
+	}
+	if c.or != nil {
+		for _, sub := range c.or {
+			if sub.match(res) {
+				return true
+			}
+		}
+		return false
+	}
+	var cmp int
+	switch c.field.kind {
+	case kindMoney:
+		minor, currency := c.field.number(res)
+		if currency != c.currency {
+			return c.op == "ne"
+		}
+		cmp = compareInt(minor, c.number)
+	case kindRate:
+		bps, _ := c.field.number(res)
+		cmp = compareInt(bps, c.number)
+	default:
+		cmp = strings.Compare(c.field.text(res), c.text)
+	}
+	switch c.op {
+	case "eq":
+		return cmp == 0
+	case "ne":
+		return cmp != 0
+	case "gt":
+		return cmp > 0
+	case "gte":
+		return cmp >= 0
+	case "lt":
+		return cmp < 0
+	}
+	return cmp <= 0
+}
+
+// compareInt - -1, 0 or 1 as a is less than, equal to or greater than b
+func compareInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// ============================================================================================================================
+// scanIndex - the narrowest index an index scan can use, an equality on status or a party in the top level and
+// ============================================================================================================================
+func (c *condition) scanIndex() (string, []string) {
+	candidates := []*condition{c}
+	if c.and != nil {
+		candidates = c.and
+	}
+	indexes := map[string]string{"status": StatusIndex, "borrower_id": BorrowerIndex, "lender_id": LenderIndex}
+	for _, sub := range candidates {
+		if index, ok := indexes[sub.name]; ok && sub.op == "eq" {
+			return index, []string{sub.text}
+		}
+	}
+	return AgreementIndex, []string{}
+}
+
+// ============================================================================================================================
+// query_agreements - get one page of the Agreements matching a structured filter, sorted on one field
+// ============================================================================================================================
+func (t *ManageLoan) query_agreements(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 1 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting query, optional page size, bookmark and include archived flag")
+	}
+	fmt.Println("start query_agreements")
+	var query AgreementQuery
+	if err := json.Unmarshal([]byte(args[0]), &query); err != nil {
+		return nil, invalidField("query", args[0], "expecting a JSON object with filter and sort")
+	}
+	cond := &condition{and: []*condition{}} //no filter matches everything
+	if query.Filter != nil {
+		var err error
+		if cond, err = compileFilter(query.Filter); err != nil {
+			return nil, err
+		}
+	}
+	var sortField *queryField
+	descending := false
+	if query.Sort != nil {
+		field, ok := queryFields[query.Sort.Field]
+		if !ok {
+			return nil, invalidField("sort.field", query.Sort.Field, "unknown or unsortable field")
+		}
+		switch query.Sort.Order {
+		case "", "asc":
+		case "desc":
+			descending = true
+		default:
+			return nil, invalidField("sort.order", query.Sort.Order, "expecting asc or desc")
+		}
+		sortField = &field
+	}
+	paging, err := parsePaging(args[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := couchQuery(stub, cond, sortField, descending, paging)
+	if err != nil && richQueryUnsupported(err) { //no CouchDB state database, evaluate the filter ourselves
+		fmt.Println("rich query unavailable, scanning index: " + err.Error())
+		page, err = scanQuery(stub, cond, sortField, descending, paging)
+	}
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end query_agreements")
+	return json.Marshal(page)
+}
+
+// ============================================================================================================================
+// richQueryUnsupported - the peer keeps its state in LevelDB, which answers no rich queries. Any other failure of a
+// rich query is a real error, a partial index scan would hide it.
+// ============================================================================================================================
+func richQueryUnsupported(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "not supported for leveldb")
+}
+
+// ============================================================================================================================
+// couchQuery - run the filter as a CouchDB selector
+// ============================================================================================================================
+func couchQuery(stub shim.ChaincodeStubInterface, cond *condition, sortField *queryField, descending bool, paging Paging) (*Page, error) {
+	clauses := []interface{}{
+		map[string]interface{}{"agreement_status": map[string]interface{}{"$exists": true}}, //only Agreement documents have a status
+	}
+	if !paging.IncludeArchived {
+		clauses = append(clauses, map[string]interface{}{"archived": map[string]interface{}{"$exists": false}})
+	}
+	if cond.field.path != "" || len(cond.and) > 0 || len(cond.or) > 0 {
+		clauses = append(clauses, cond.selector())
+	}
+	query := map[string]interface{}{}
+	if sortField != nil {
+		path := sortField.path
+		if sortField.kind == kindMoney {
+			path += ".minor_units"
+		}
+		order := "asc"
+		if descending {
+			order = "desc"
+		}
+		clauses = append(clauses, map[string]interface{}{path: map[string]interface{}{"$exists": true}}) //lets CouchDB use the sort index
+		query["sort"] = []interface{}{map[string]string{path: order}}
+	}
+	query["selector"] = map[string]interface{}{"$and": clauses}
+	queryAsBytes, _ := json.Marshal(query)
+
+	iter, meta, err := stub.GetQueryResultWithPagination(string(queryAsBytes), paging.PageSize, paging.Bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	page := &Page{Records: []*Agreement{}}
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		res, err := decodeAgreement(kv.Key, kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		page.Records = append(page.Records, res)
+	}
+	if meta != nil && len(page.Records) == int(paging.PageSize) {
+		page.Bookmark = meta.Bookmark
+	}
+	if page.Records, err = visibleAgreements(stub, page.Records); err != nil {
+		return nil, err
+	}
+	page.Count = len(page.Records)
+	return page, nil
+}
+
+// ============================================================================================================================
+// scanQuery - evaluate the filter over an index scan, the bookmark is the offset of the next page
+// ============================================================================================================================
+func scanQuery(stub shim.ChaincodeStubInterface, cond *condition, sortField *queryField, descending bool, paging Paging) (*Page, error) {
+	offset := 0
+	if paging.Bookmark != "" {
+		n, err := strconv.Atoi(paging.Bookmark)
+		if err != nil || n < 0 {
+			return nil, invalidField("bookmark", paging.Bookmark, "not a bookmark returned by query_agreements")
+		}
+		offset = n
+	}
+	index, attributes := cond.scanIndex()
+	iter, err := stub.GetStateByPartialCompositeKey(index, attributes)
+	if err != nil {
+		return nil, errors.New("Failed to read index " + index)
+	}
+	defer iter.Close()
+	var matches []*Agreement
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		_, parts, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		res, err := getAgreement(stub, parts[len(parts)-1])
+		if err != nil {
+			return nil, err
+		}
+		if cond.match(res) && (paging.IncludeArchived || res.Archived == nil) {
+			matches = append(matches, res)
+		}
+	}
+
+	if matches, err = visibleAgreements(stub, matches); err != nil { //before paging, so pages stay full
+		return nil, err
+	}
+	if sortField != nil {
+		sort.SliceStable(matches, func(i, j int) bool {
+			var cmp int
+			if sortField.kind == kindMoney || sortField.kind == kindRate {
+				a, ca := sortField.number(matches[i])
+				b, cb := sortField.number(matches[j])
+				if cmp = strings.Compare(ca, cb); cmp == 0 {
+					cmp = compareInt(a, b)
+				}
+			} else {
+				cmp = strings.Compare(sortField.text(matches[i]), sortField.text(matches[j]))
+			}
+			if descending {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+	}
+
+	page := &Page{Records: []*Agreement{}}
+	if offset < len(matches) {
+		end := offset + int(paging.PageSize)
+		if end >= len(matches) {
+			end = len(matches)
+		} else {
+			page.Bookmark = strconv.Itoa(end)
+		}
+		page.Records = matches[offset:end]
+	}
+	page.Count = len(page.Records)
+	return page, nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+// expectPage - check a listing returned exactly the given Agreements, in order
+func expectPage(ids ...string) func(t *testing.T, c *testChain, payload []byte) {
+	return func(t *testing.T, c *testChain, payload []byte) {
+		var page Page
+		if err := json.Unmarshal(payload, &page); err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, res := range page.Records {
+			got = append(got, res.AgreeementID)
+		}
+		if len(got) != len(ids) || page.Count != len(ids) {
+			t.Fatalf("got %v, want %v", got, ids)
+		}
+		for i := range ids {
+			if got[i] != ids[i] {
+				t.Fatalf("got %v, want %v", got, ids)
+			}
+		}
+	}
+}
+
+func TestGetAgreementByID(t *testing.T) {
+	runCases(t, []chainCase{
+		{name: "party", caller: "alice", function: "getAgreement_byID", args: []string{"A1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var res Agreement
+				if err := json.Unmarshal(payload, &res); err != nil || res.Comments != "first" || res.SchemaVersion != SchemaVersion {
+					t.Errorf("got %s", payload)
+				}
+			}},
+		{name: "auditor", caller: "auditor", function: "getAgreement_byID", args: []string{"A3"}},
+		{name: "JSON form", caller: "auditor", function: "getAgreement_byID", args: []string{`{"agreement_id":"A1"}`},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var response struct {
+					Status string
+					Data   Agreement
+				}
+				if err := json.Unmarshal(payload, &response); err != nil || response.Status != "ok" || response.Data.AgreeementID != "A1" {
+					t.Errorf("got %s", payload)
+				}
+			}},
+		{name: "not a party", caller: "carol", function: "getAgreement_byID", args: []string{"A1"}, code: ErrForbidden},
+		{name: "unknown id", caller: "admin", function: "getAgreement_byID", args: []string{"X9"}, code: ErrNotFound},
+		{name: "no arguments", caller: "admin", function: "getAgreement_byID", code: ErrInvalidArgument},
+	}, true)
+}
+
+func TestListings(t *testing.T) {
+	archiveA1 := func(c *testChain) { c.mustInvoke("servicer", "delete_po", "A1", "duplicate") }
+	runCases(t, []chainCase{
+		{name: "by lender", caller: "bob", function: "getAgreement_byBuyer", args: []string{"Bob"}, check: expectPage("A1", "A2", "A3")},
+		{name: "by lender, first page", caller: "bob", function: "getAgreement_byBuyer", args: []string{"Bob", "2"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectPage("A1", "A2")(t, c, payload)
+				var page Page
+				json.Unmarshal(payload, &page)
+				next, err := c.query("bob", "getAgreement_byBuyer", "Bob", "2", page.Bookmark)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expectPage("A3")(t, c, next)
+			}},
+		{name: "by borrower without the archived", setup: archiveA1, caller: "alice", function: "getAgreement_bySeller", args: []string{"Alice"},
+			check: expectPage("A2", "A3")},
+		{name: "by borrower with the archived", setup: archiveA1, caller: "alice", function: "getAgreement_bySeller", args: []string{"Alice", "", "", "true"},
+			check: expectPage("A1", "A2", "A3")},
+		{name: "all", caller: "auditor", function: "get_AllAgreement", check: expectPage("A1", "A2", "A3")},
+		{name: "all, as a stranger", caller: "carol", function: "get_AllAgreement", check: expectPage()},
+		{name: "bad page size", caller: "auditor", function: "get_AllAgreement", args: []string{"-1"}, code: ErrInvalidArgument},
+		{name: "all, with the old dummy argument", caller: "auditor", function: "get_AllAgreement", args: []string{"all"}, check: expectPage("A1", "A2", "A3")},
+		{name: "by status", caller: "servicer", function: "getAgreement_byStatus", args: []string{"Active"}, check: expectPage("A3")},
+		{name: "unknown status", caller: "servicer", function: "getAgreement_byStatus", args: []string{"pending"}, code: ErrInvalidArgument},
+		{name: "by repayment date", caller: "servicer", function: "getAgreement_byRepaymentDate", args: []string{"2027-01-15", "2027-02-28"},
+			check: expectPage("A1", "A2")},
+		{name: "by repayment date, one page at a time", caller: "servicer", function: "getAgreement_byRepaymentDate", args: []string{"2027-01-20", "2027-03-31", "1"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				expectPage("A2")(t, c, payload)
+				var page Page
+				json.Unmarshal(payload, &page)
+				next, err := c.query("servicer", "getAgreement_byRepaymentDate", "2027-01-20", "2027-03-31", "1", page.Bookmark)
+				if err != nil {
+					t.Fatal(err)
+				}
+				expectPage("A3")(t, c, next)
+				if json.Unmarshal(next, &page); page.Bookmark != "" {
+					t.Errorf("nothing left after A3, got bookmark %q", page.Bookmark)
+				}
+			}},
+		{name: "by repayment date, bad date", caller: "servicer", function: "getAgreement_byRepaymentDate", args: []string{"2027-01", "2027-02-28"},
+			code: ErrInvalidArgument},
+		{name: "query, scanning the indexes", caller: "auditor", function: "query_agreements",
+			args:  []string{`{"filter":{"or":[{"field":"status","op":"eq","value":"draft"},{"field":"repayment_date","op":"gte","value":"2027-03-01"}]},"sort":{"field":"repayment_date","order":"desc"}}`},
+			check: expectPage("A3", "A1")},
+		{name: "query on money", caller: "auditor", function: "query_agreements",
+			args: []string{`{"filter":{"field":"loan_amount","op":"gte","value":"10000 USD"}}`, "2"}, check: expectPage("A1", "A2")},
+		{name: "query on an unknown field", caller: "auditor", function: "query_agreements", args: []string{`{"filter":{"field":"colour","op":"eq","value":"red"}}`},
+			code: ErrInvalidArgument},
+		{name: "query, CouchDB failing", caller: "auditor", function: "query_agreements", args: []string{`{"filter":{"field":"status","op":"eq","value":"draft"}}`},
+			setup: func(c *testChain) { c.stub.queryErr = errors.New("couchdb: connection refused") },
+			code:  ErrInternal},
+	}, true)
+}
+
+func TestStatusSelector(t *testing.T) {
+	stored := []string{"draft", "Active", " OVERDUE ", "repaid", "", "Cancelled"}
+	for _, filter := range []string{
+		`{"field":"status","op":"eq","value":"active"}`,
+		`{"field":"status","op":"ne","value":"active"}`,
+		`{"field":"status","op":"lt","value":"draft"}`,
+		`{"field":"status","op":"eq","value":"draft"}`,
+		`{"field":"status","op":"lt","value":"cancelled"}`,
+	} {
+		var f Filter
+		if err := json.Unmarshal([]byte(filter), &f); err != nil {
+			t.Fatal(err)
+		}
+		cond, err := compileFilter(&f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clause := cond.selector()["agreement_status"].(map[string]interface{})
+		pattern, _ := clause["$regex"].(string)
+		for _, status := range stored {
+			couch := pattern != "" && regexp.MustCompile(pattern).MatchString(status)
+			if scan := cond.match(&Agreement{AgreementStatus: status}); couch != scan {
+				t.Errorf("%s on %q: selector %v, scan %v", filter, status, couch, scan)
+			}
+		}
+	}
+}
+
+func TestRepaymentsAndSchedule(t *testing.T) {
+	repaid := func(c *testChain) {
+		c.mustInvoke("bob", "record_repayment", "A3", "888.49", "2026-02-15", "Alice", "r1")
+		c.mustInvoke("bob", "record_repayment", "A3", "888.49", "2026-03-15", "Alice", "r2")
+	}
+	runCases(t, []chainCase{
+		{name: "repayments", setup: repaid, caller: "alice", function: "getRepayments_byAgreement", args: []string{"A3"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var repayments []Repayment
+				if err := json.Unmarshal(payload, &repayments); err != nil || len(repayments) != 2 {
+					t.Fatalf("got %s", payload)
+				}
+				if last := repayments[1]; last.Reference != "r2" || last.BalanceAfter.MinorUnits != 1000000-2*88849 {
+					t.Errorf("got %+v", last)
+				}
+			}},
+		{name: "repayments, not a party", setup: repaid, caller: "carol", function: "getRepayments_byAgreement", args: []string{"A3"}, code: ErrForbidden},
+		{name: "schedule", caller: "alice", function: "getSchedule", args: []string{"A3"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var schedule Schedule
+				if err := json.Unmarshal(payload, &schedule); err != nil || len(schedule.Installments) != 12 {
+					t.Fatalf("got %s", payload)
+				}
+				first, last := schedule.Installments[0], schedule.Installments[11]
+				if first.Payment.MinorUnits != 88849 || first.Interest.MinorUnits != 10000 || first.DueDate != "2026-02-15" {
+					t.Errorf("first installment %+v", first)
+				}
+				if last.RemainingBalance.MinorUnits != 0 || schedule.TotalPayment.MinorUnits != 1000000+schedule.TotalInterest.MinorUnits {
+					t.Errorf("last installment %+v of %+v", last, schedule)
+				}
+			}},
+		{name: "schedule, unknown id", caller: "alice", function: "getSchedule", args: []string{"X9"}, code: ErrNotFound},
+		{name: "schedule of a stored term over the cap", caller: "alice", function: "getSchedule", args: []string{"A3"},
+			setup: func(c *testChain) {
+				res := c.agreement("A3")
+				res.LoanDuration = Duration{Value: 1000000000, Unit: "year"}
+				c.stub.begin(nil)
+				putDocument(c.stub, "A3", res)
+				c.stub.commit()
+			},
+			code: ErrInvalidArgument},
+	}, true)
+}
+
+func TestHistoryAndSchema(t *testing.T) {
+	updated := func(c *testChain) { c.mustInvoke("alice", "update_agreement", "A1", `{"comments":"revised"}`) }
+	runCases(t, []chainCase{
+		{name: "history with changes", setup: updated, caller: "bob", function: "getAgreement_history", args: []string{"A1", "true"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var history []HistoryEntry
+				if err := json.Unmarshal(payload, &history); err != nil || len(history) != 2 {
+					t.Fatalf("got %s", payload)
+				}
+				changed := map[string]bool{}
+				for _, change := range history[1].Changes {
+					changed[change.Field] = true
+				}
+				if len(changed) != 2 || !changed["comments"] || !changed["version"] {
+					t.Errorf("got changes %+v", history[1].Changes)
+				}
+			}},
+		{name: "history, not a party", caller: "carol", function: "getAgreement_history", args: []string{"A1"}, code: ErrForbidden},
+		{name: "history, unknown id", caller: "admin", function: "getAgreement_history", args: []string{"X9"}, code: ErrNotFound},
+		{name: "schema of one function", caller: "carol", function: "get_schema", args: []string{"record_repayment"},
+			check: func(t *testing.T, c *testChain, payload []byte) {
+				var schemas []FunctionSchema
+				if err := json.Unmarshal(payload, &schemas); err != nil || len(schemas) != 1 || len(schemas[0].Params) != 6 {
+					t.Errorf("got %s", payload)
+				}
+			}},
+		{name: "schema of an unknown function", caller: "carol", function: "get_schema", args: []string{"transfer"}, code: ErrInvalidArgument},
+	}, true)
+}
+
+// TestSchemaCoversPolicies - every function with an access policy is published in the schema and the other way round
+func TestSchemaCoversPolicies(t *testing.T) {
+	for kind, policies := range map[string]map[string][]Role{KindInvoke: invokePolicies, KindQuery: queryPolicies} {
+		for function := range policies {
+			if _, ok := findSchema(kind, function); !ok {
+				t.Errorf("%s %s has no schema", kind, function)
+			}
+		}
+	}
+	for _, schema := range apiSchema {
+		policies := queryPolicies
+		if schema.Kind == KindInvoke {
+			policies = invokePolicies
+		}
+		if _, ok := policies[schema.Function]; !ok {
+			t.Errorf("%s %s has no access policy", schema.Kind, schema.Function)
+		}
+	}
+}
+
+func TestLoanContract(t *testing.T) {
+	c := seededChain(t)
+	contract := new(LoanContract)
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(c.stub)
+
+	c.stub.begin(c.ids["bob"])
+	if err := contract.UpdateStatus(ctx, "A1", "proposed", 1); err != nil {
+		t.Fatal(err)
+	}
+	c.stub.commit()
+	c.stub.begin(c.ids["alice"])
+	payload, err := contract.GetAgreement(ctx, "A1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res Agreement
+	if err = json.Unmarshal([]byte(payload), &res); err != nil || currentStatus(&res) != StatusProposed || res.Version != 2 {
+		t.Errorf("got %s", payload)
+	}
+	c.stub.begin(c.ids["bob"])
+	if err = contract.UpdateStatus(ctx, "A1", "cancelled", 1); errorCode(err) != ErrConflict {
+		t.Errorf("got %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// RepaymentObjectType - composite key prefix for repayment entries, keyed by agreement id, date and tx id
+const RepaymentObjectType = "repayment"
+
+// DateLayout - layout of every date argument and date field
+const DateLayout = "2006-01-02"
+
+type Repayment struct { // One payment made against an Agreement
+	Document
+	AgreementID  string `json:"agreement_id"`
+	Amount       Money  `json:"amount"`
+	Date         string `json:"date"`
+	Payer        string `json:"payer"`
+	Reference    string `json:"reference"`
+	PenaltyPaid  *Money `json:"penalty_paid,omitempty"`  // part of the amount settling penalty interest
+	InterestPaid *Money `json:"interest_paid,omitempty"` // part settling accrued interest, the rest repays principal
+	BalanceAfter Money  `json:"balance_after"`
+	RecordedBy   string `json:"recorded_by"`
+	Timestamp    string `json:"timestamp"`
+	TxID         string `json:"tx_id"`
+}
+
+// ============================================================================================================================
+// outstandingBalance - amount still owed on an Agreement, the full loan amount until the first repayment
+// ============================================================================================================================
+func outstandingBalance(res *Agreement) (Money, error) {
+	balance := res.LoanAmount
+	if res.OutstandingBalance != nil {
+		balance = *res.OutstandingBalance
+	}
+	if !balance.Valid() {
+		return balance, fmt.Errorf("Agreement %s has an unreadable balance %q", res.AgreeementID, balance.Raw)
+	}
+	return balance, nil
+}
+
+// ============================================================================================================================
+// applyPayment - settle penalty interest, then accrued interest, then principal with a payment, returning the penalty and
+// interest parts. The amount must not exceed what is owed.
+// ============================================================================================================================
+func applyPayment(res *Agreement, balance *Money, amount Money) (Money, Money) {
+	penalty, interest := balance.Amount(0), balance.Amount(0)
+	left := amount.MinorUnits
+	if res.Overdue != nil {
+		penalty.MinorUnits = min(left, res.Overdue.PenaltyInterest.MinorUnits)
+		res.Overdue.PenaltyInterest.MinorUnits -= penalty.MinorUnits
+		left -= penalty.MinorUnits
+	}
+	if res.AccruedInterest != nil {
+		interest.MinorUnits = min(left, res.AccruedInterest.MinorUnits)
+		res.AccruedInterest.MinorUnits -= interest.MinorUnits
+		left -= interest.MinorUnits
+	}
+	balance.MinorUnits -= left
+	return penalty, interest
+}
+
+// ============================================================================================================================
+// amountOwed - principal, accrued interest and penalty interest still to pay on an Agreement
+// ============================================================================================================================
+func amountOwed(res *Agreement, balance Money) Money {
+	owed := balance
+	if res.AccruedInterest != nil {
+		owed.MinorUnits += res.AccruedInterest.MinorUnits
+	}
+	if res.Overdue != nil {
+		owed.MinorUnits += res.Overdue.PenaltyInterest.MinorUnits
+	}
+	return owed
+}
+
+// ============================================================================================================================
+// record_repayment - store a repayment against an Agreement, settling penalty interest, accrued interest and then principal
+// ============================================================================================================================
+func (t *ManageLoan) record_repayment(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 5 && len(args) != 6 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting 5, or 6 with the expected version")
+	}
+	fmt.Println("start record_repayment")
+	agreement_id := args[0]
+	date := args[2]
+	if _, err := time.Parse(DateLayout, date); err != nil {
+		return nil, invalidField("date", date, "expecting YYYY-MM-DD")
+	}
+	payer := args[3]
+	reference := args[4]
+
+	res, err := getAgreement(stub, agreement_id)
+	if err != nil {
+		return nil, err
+	}
+	if err = authorizeAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	if err = checkVersion(res, optionalArg(args, 5)); err != nil {
+		return nil, err
+	}
+	status := currentStatus(res)
+	if !outstandingStatuses[status] {
+		return nil, invalidTransition("agreement_status", "Agreement %s is %s, repayments need an active, overdue or defaulted loan", agreement_id, status)
+	}
+	if _, err = accrue(stub, res); err != nil { //interest up to today runs on the balance before this payment
+		return nil, err
+	}
+	balance, err := outstandingBalance(res)
+	if err != nil {
+		return nil, err
+	}
+	amount_text := args[1]
+	if len(strings.Fields(amount_text)) == 1 { //a bare amount is in the currency of the loan
+		amount_text += " " + balance.Currency
+	}
+	amount, err := ParseMoney("amount", amount_text)
+	if err != nil {
+		return nil, err
+	}
+	if amount.Currency != balance.Currency {
+		return nil, invalidField("amount", args[1], "currency must be "+balance.Currency)
+	}
+	if owed := amountOwed(res, balance); amount.MinorUnits > owed.MinorUnits {
+		return nil, invalidField("amount", args[1], "exceeds the amount owed of "+owed.String())
+	}
+	penalty, interest := applyPayment(res, &balance, amount)
+
+	caller, err := getCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	repayment := Repayment{
+		AgreementID:  agreement_id,
+		Amount:       amount,
+		Date:         date,
+		Payer:        payer,
+		Reference:    reference,
+		PenaltyPaid:  &penalty,
+		InterestPaid: &interest,
+		BalanceAfter: balance,
+		RecordedBy:   caller.String(),
+		Timestamp:    now.Format(time.RFC3339),
+		TxID:         stub.GetTxID(),
+	}
+	key, err := stub.CreateCompositeKey(RepaymentObjectType, []string{agreement_id, date, stub.GetTxID()})
+	if err != nil {
+		return nil, err
+	}
+	if err = putDocument(stub, key, &repayment); err != nil {
+		return nil, err
+	}
+
+	res.OutstandingBalance = &balance
+	if amountOwed(res, balance).MinorUnits == 0 { //principal and every interest paid
+		if err = transition(stub, res, StatusRepaid); err != nil {
+			return nil, err
+		}
+	}
+	if err = putAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	if err = emitEvent(stub, EventRepaymentRecorded, agreement_id, status, currentStatus(res)); err != nil {
+		return nil, err
+	}
+	fmt.Println("end record_repayment")
+	return nil, nil
+}
+
+// ============================================================================================================================
+// getRepayments_byAgreement - get every repayment recorded against an Agreement, oldest first
+// ============================================================================================================================
+func (t *ManageLoan) getRepayments_byAgreement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting 1")
+	}
+	fmt.Println("start getRepayments_byAgreement")
+	res, err := getAgreement(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if err = authorizeAgreement(stub, res); err != nil {
+		return nil, err
+	}
+	iter, err := stub.GetStateByPartialCompositeKey(RepaymentObjectType, []string{args[0]})
+	if err != nil {
+		return nil, errors.New("Failed to get repayments for " + args[0])
+	}
+	defer iter.Close()
+	repayments := []Repayment{}
+	for iter.HasNext() {
+		kv, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		var repayment Repayment
+		if err = decodeDocument(kv.Value, repaymentMigrations, &repayment); err != nil {
+			return nil, errors.New("Failed to decode repayment " + kv.Key)
+		}
+		repayments = append(repayments, repayment)
+	}
+	fmt.Println("end getRepayments_byAgreement")
+	return json.Marshal(repayments)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// RepaymentType - how the principal of a loan is paid back over its installments
+type RepaymentType string
+
+const (
+	RepaymentAnnuity        RepaymentType = "annuity"         // equal installments of principal plus interest
+	RepaymentEqualPrincipal RepaymentType = "equal_principal" // equal principal parts, interest on the remaining balance
+	RepaymentBullet         RepaymentType = "bullet"          // interest only, principal repaid with the last installment
+)
+
+type Installment struct { // One due payment of a repayment schedule
+	Number           int    `json:"number"`
+	DueDate          string `json:"due_date"`
+	Payment          Money  `json:"payment"`
+	Principal        Money  `json:"principal"`
+	Interest         Money  `json:"interest"`
+	RemainingBalance Money  `json:"remaining_balance"`
+}
+
+type Schedule struct { // Full repayment schedule of an Agreement
+	AgreementID   string        `json:"agreement_id"`
+	RepaymentType RepaymentType `json:"repayment_type"`
+	Installments  []Installment `json:"installments"`
+	TotalInterest Money         `json:"total_interest"`
+	TotalPayment  Money         `json:"total_payment"`
+}
+
+// ============================================================================================================================
+// parseRepaymentType - accept a repayment type in any letter case, empty means annuity
+// ============================================================================================================================
+func parseRepaymentType(s string) (RepaymentType, error) {
+	switch rt := RepaymentType(strings.ToLower(strings.TrimSpace(s))); rt {
+	case "":
+		return RepaymentAnnuity, nil
+	case RepaymentAnnuity, RepaymentEqualPrincipal, RepaymentBullet:
+		return rt, nil
+	}
+	return "", invalidField("repayment_type", s, "expecting annuity, equal_principal or bullet")
+}
+
+// ============================================================================================================================
+// addMonths - same day n months later, clamped to the last day of shorter months
+// ============================================================================================================================
+func addMonths(d time.Time, n int) time.Time {
+	first := time.Date(d.Year(), d.Month()+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 1, -1).Day()
+	day := d.Day()
+	if day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// ============================================================================================================================
+// roundCents - round an exact amount in minor units to a whole number, halves away from zero
+// ============================================================================================================================
+func roundCents(r *big.Rat) int64 {
+	num := new(big.Int).Abs(r.Num())
+	q, m := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+	if m.Mul(m, big.NewInt(2)).Cmp(r.Denom()) >= 0 {
+		q.Add(q, big.NewInt(1))
+	}
+	if r.Sign() < 0 {
+		q.Neg(q)
+	}
+	return q.Int64()
+}
+
+// ============================================================================================================================
+// buildSchedule - compute the installments of a loan with exact rational math, rounded to minor units once per step
+// ============================================================================================================================
+func buildSchedule(loan Money, rate Rate, months int, start time.Time, rt RepaymentType) ([]Installment, int64) {
+	principal := loan.MinorUnits
+	monthly := big.NewRat(rate.BasisPoints, 10000*12)
+	interestOn := func(balance int64) int64 {
+		return roundCents(new(big.Rat).Mul(big.NewRat(balance, 1), monthly))
+	}
+
+	var annuity int64
+	if rt == RepaymentAnnuity {
+		if monthly.Sign() == 0 {
+			annuity = roundCents(big.NewRat(principal, int64(months)))
+		} else { // P * i * (1+i)^n / ((1+i)^n - 1)
+			growth := new(big.Rat).Add(big.NewRat(1, 1), monthly)
+			pow := big.NewRat(1, 1)
+			for i := 0; i < months; i++ {
+				pow.Mul(pow, growth)
+			}
+			num := new(big.Rat).Mul(big.NewRat(principal, 1), monthly)
+			num.Mul(num, pow)
+			annuity = roundCents(num.Quo(num, pow.Sub(pow, big.NewRat(1, 1))))
+		}
+	}
+	equalPart := roundCents(big.NewRat(principal, int64(months)))
+
+	installments := make([]Installment, 0, months)
+	balance, totalInterest := principal, int64(0)
+	for n := 1; n <= months; n++ {
+		interest := interestOn(balance)
+		var part int64
+		switch rt {
+		case RepaymentAnnuity:
+			part = annuity - interest
+		case RepaymentEqualPrincipal:
+			part = equalPart
+		case RepaymentBullet:
+			part = 0
+		}
+		if n == months || part > balance { //the last installment clears whatever rounding left over
+			part = balance
+		}
+		if part < 0 {
+			part = 0
+		}
+		balance -= part
+		totalInterest += interest
+		installments = append(installments, Installment{
+			Number:           n,
+			DueDate:          addMonths(start, n).Format(DateLayout),
+			Payment:          loan.Amount(part + interest),
+			Principal:        loan.Amount(part),
+			Interest:         loan.Amount(interest),
+			RemainingBalance: loan.Amount(balance),
+		})
+	}
+	return installments, totalInterest
+}
+
+// ============================================================================================================================
+// getSchedule - get the installment schedule of an Agreement from its amount, rate, duration and repayment type
+// ============================================================================================================================
+func (t *ManageLoan) getSchedule(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting 1")
+	}
+	fmt.Println("start getSchedule")
+	agreement_id := args[0]
+	res, err := getAgreement(stub, agreement_id)
+	if err != nil {
+		return nil, err
+	}
+	if err = authorizeAgreement(stub, res); err != nil {
+		return nil, err
+	}
+
+	if !res.LoanAmount.Valid() || !res.InterestRate.Valid() {
+		return nil, fmt.Errorf("Agreement %s has an unreadable loan amount or interest rate", agreement_id)
+	}
+	if res.LoanDuration.Value > maxDuration[res.LoanDuration.Unit] { //stored before ParseDuration capped the term
+		return nil, invalidField("loan_duration", res.LoanDuration.String(), fmt.Sprintf("schedules cover at most %d months", MaxLoanMonths))
+	}
+	months, ok := res.LoanDuration.Months()
+	if !ok {
+		return nil, invalidField("loan_duration", res.LoanDuration.String(), "schedules need a duration in months or years")
+	}
+	start, err := time.Parse(DateLayout, res.AgreementDate)
+	if err != nil {
+		return nil, invalidField("agreement_date", res.AgreementDate, "expecting YYYY-MM-DD")
+	}
+	rt, err := parseRepaymentType(res.RepaymentType)
+	if err != nil {
+		return nil, err
+	}
+
+	installments, totalInterest := buildSchedule(res.LoanAmount, res.InterestRate, months, start, rt)
+	schedule := Schedule{AgreementID: agreement_id, RepaymentType: rt, Installments: installments}
+	schedule.TotalInterest = res.LoanAmount.Amount(totalInterest)
+	schedule.TotalPayment = res.LoanAmount.Amount(res.LoanAmount.MinorUnits + totalInterest)
+	fmt.Println("end getSchedule")
+	return json.Marshal(schedule)
+}
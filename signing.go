@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+type Signature struct { // Proof that a party agreed to the terms of an Agreement
+	Signer      string `json:"signer"`
+	MSPID       string `json:"msp_id"`
+	Fingerprint string `json:"cert_fingerprint"`
+	Timestamp   string `json:"timestamp"`
+	TxID        string `json:"tx_id"`
+}
+
+// ============================================================================================================================
+// awaitingSignatures - the terms are out for signing and may not change any more
+// ============================================================================================================================
+func awaitingSignatures(res *Agreement) bool {
+	return currentStatus(res) == StatusProposed
+}
+
+// ============================================================================================================================
+// borrower_sign - the borrower named on the Agreement signs it
+// ============================================================================================================================
+func (t *ManageLoan) borrower_sign(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return t.sign(stub, args, true)
+}
+
+// ============================================================================================================================
+// lender_sign - the lender named on the Agreement signs it
+// ============================================================================================================================
+func (t *ManageLoan) lender_sign(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return t.sign(stub, args, false)
+}
+
+// ============================================================================================================================
+// sign - store the caller's signature for one side and activate the Agreement once both sides have signed
+// ============================================================================================================================
+func (t *ManageLoan) sign(stub shim.ChaincodeStubInterface, args []string, borrower bool) ([]byte, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, invalidArgs("Incorrect number of arguments. Expecting agreement_id and an optional expected version")
+	}
+	fmt.Println("start sign")
+	agreement_id := args[0]
+	res, err := getAgreement(stub, agreement_id)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkVersion(res, optionalArg(args, 1)); err != nil {
+		return nil, err
+	}
+	if !awaitingSignatures(res) {
+		return nil, invalidTransition("agreement_status", "Agreement %s is %s, only proposed Agreements can be signed", agreement_id, currentStatus(res))
+	}
+
+	caller, err := getCaller(stub)
+	if err != nil {
+		return nil, err
+	}
+	party, existing := res.LenderID, res.LenderSignature
+	if borrower {
+		party, existing = res.BorrowerID, res.BorrowerSignature
+	}
+	if caller.Party == "" || party == "" || caller.Party != party { //nobody signs for a blank party, legacy records may name none
+		return nil, forbidden("Caller %s is not %s, the party named on Agreement %s", caller.Party, party, agreement_id)
+	}
+	if existing != nil {
+		return nil, alreadyExists("agreement_id", agreement_id, party+" has already signed Agreement "+agreement_id)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	sig := &Signature{
+		Signer:      caller.Party,
+		MSPID:       caller.MSPID,
+		Fingerprint: caller.Fingerprint,
+		Timestamp:   now.Format(time.RFC3339),
+		TxID:        stub.GetTxID(),
+	}
+	from := currentStatus(res)
+	if borrower {
+		res.BorrowerSignature = sig
+		res.BorrowerSigned = "true"
+	} else {
+		res.LenderSignature = sig
+		res.LenderSigned = "true"
+	}
+
+	if res.BorrowerSignature != nil && res.LenderSignature != nil { //fully signed, the loan starts right away
+		if err = transition(stub, res, StatusSigned); err != nil {
+			return nil, err
+		}
+		if err = transition(stub, res, StatusActive); err != nil {
+			return nil, err
+		}
+	}
+	err = putAgreement(stub, res)
+	if err != nil {
+		return nil, err
+	}
+	err = emitEvent(stub, EventAgreementSigned, agreement_id, from, currentStatus(res))
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("end sign")
+	return nil, nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+// SchemaVersion - version stamped on every document this chaincode writes.
+// Version 1 is the layout written before documents carried a schema_version:
+// plain strings for amounts, rates and durations and free-text statuses.
+// Version 2 Agreements named their parties by free text only, without party ids.
+const SchemaVersion = 3
+
+type Document struct { // Header shared by every ledger document
+	SchemaVersion int `json:"schema_version"`
+}
+
+func (d *Document) header() *Document { return d }
+
+// ledgerDocument - any struct embedding Document
+type ledgerDocument interface {
+	header() *Document
+}
+
+// migration - upgrade a decoded document from one schema version to the next
+type migration func(doc map[string]interface{}) error
+
+// agreementMigrations / repaymentMigrations / collateralMigrations - keyed by the version they upgrade from
+var agreementMigrations = map[int]migration{
+	1: migrateAgreementV1,
+	2: migrateAgreementV2,
+}
+var repaymentMigrations = map[int]migration{
+	1: migrateRepaymentV1,
+}
+var collateralMigrations = map[int]migration{} //collateral was added at schema version 2
+
+// ============================================================================================================================
+// putDocument - serialize a document at the current schema version and store it, the only way documents are written
+// ============================================================================================================================
+func putDocument(stub shim.ChaincodeStubInterface, key string, doc ledgerDocument) error {
+	doc.header().SchemaVersion = SchemaVersion
+	jsonAsBytes, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("Failed to encode document %s: %s", key, err)
+	}
+	return stub.PutState(key, jsonAsBytes)
+}
+
+// ============================================================================================================================
+// decodeDocument - decode a stored document into out, upgrading it from older schema versions first
+// ============================================================================================================================
+func decodeDocument(raw []byte, migrations map[int]migration, out ledgerDocument) error {
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber() //keep minor units exact
+	if err := decoder.Decode(&doc); err != nil {
+		return err
+	}
+	version := 1
+	if v, ok := doc["schema_version"].(json.Number); ok {
+		n, err := v.Int64()
+		if err != nil {
+			return fmt.Errorf("Invalid schema_version %s", v)
+		}
+		version = int(n)
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("Document has schema version %d, this chaincode reads up to %d", version, SchemaVersion)
+	}
+	for ; version < SchemaVersion; version++ {
+		if migrate, ok := migrations[version]; ok {
+			if err := migrate(doc); err != nil {
+				return fmt.Errorf("Failed to upgrade document from schema version %d: %s", version, err)
+			}
+		}
+	}
+	doc["schema_version"] = SchemaVersion
+	upgraded, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(upgraded, out)
+}
+
+// ============================================================================================================================
+// getAgreement - read an Agreement at the current schema version, error when it does not exist
+// ============================================================================================================================
+func getAgreement(stub shim.ChaincodeStubInterface, agreement_id string) (*Agreement, error) {
+	poAsBytes, err := stub.GetState(agreement_id)
+	if err != nil {
+		return nil, errors.New("Failed to get state for " + agreement_id)
+	}
+	if poAsBytes == nil {
+		return nil, notFound("agreement_id", agreement_id, "Agreement not found: "+agreement_id)
+	}
+	return decodeAgreement(agreement_id, poAsBytes)
+}
+
+// ============================================================================================================================
+// decodeAgreement - decode a stored Agreement at the current schema version
+// ============================================================================================================================
+func decodeAgreement(agreement_id string, poAsBytes []byte) (*Agreement, error) {
+	res := Agreement{}
+	if err := decodeDocument(poAsBytes, agreementMigrations, &res); err != nil {
+		return nil, fmt.Errorf("Failed to decode Agreement %s: %s", agreement_id, err)
+	}
+	return &res, nil
+}
+
+// ============================================================================================================================
+// putAgreement - store an Agreement under its id with the next version number and keep its secondary index entries in step
+// ============================================================================================================================
+func putAgreement(stub shim.ChaincodeStubInterface, res *Agreement) error {
+	var old *Agreement
+	res.Version = 1
+	poAsBytes, err := stub.GetState(res.AgreeementID)
+	if err != nil {
+		return errors.New("Failed to get state for " + res.AgreeementID)
+	}
+	if poAsBytes != nil {
+		if old, err = decodeAgreement(res.AgreeementID, poAsBytes); err != nil {
+			return err
+		}
+		if old.Archived != nil && res.Archived != nil {
+			return invalidTransition("archived", "Agreement %s is archived, restore it before changing it", res.AgreeementID)
+		}
+		res.Version = old.Version + 1
+	}
+	if err = putDocument(stub, res.AgreeementID, res); err != nil {
+		return err
+	}
+	return updateIndexes(stub, old, res)
+}
+
+// ============================================================================================================================
+// deleteAgreement - remove an Agreement and its secondary index entries
+// ============================================================================================================================
+func deleteAgreement(stub shim.ChaincodeStubInterface, res *Agreement) error {
+	if err := stub.DelState(res.AgreeementID); err != nil {
+		return errors.New("Failed to delete state")
+	}
+	return updateIndexes(stub, res, nil)
+}
+
+// ============================================================================================================================
+// migrateAgreementV1 - type the string amount, rate and duration and normalize the letter case of the status
+// ============================================================================================================================
+func migrateAgreementV1(doc map[string]interface{}) error {
+	for _, field := range []string{"loan_amount", "outstanding_balance"} {
+		if s, ok := doc[field].(string); ok {
+			doc[field] = legacyMoney(s)
+		}
+	}
+	if s, ok := doc["interest_rate"].(string); ok {
+		doc["interest_rate"] = legacyRate(s)
+	}
+	if s, ok := doc["loan_duration"].(string); ok {
+		doc["loan_duration"] = legacyDuration(s)
+	}
+	if s, ok := doc["agreement_status"].(string); ok {
+		if status, err := parseStatus(s); err == nil {
+			doc["agreement_status"] = string(status)
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// migrateAgreementV2 - the free-text party names become the party ids, matching the index entries already stored
+// ============================================================================================================================
+func migrateAgreementV2(doc map[string]interface{}) error {
+	for id, name := range map[string]string{"borrower_id": "borrower_name", "lender_id": "lender_name"} {
+		if _, ok := doc[id]; !ok {
+			doc[id] = doc[name]
+		}
+	}
+	return nil
+}
+
+// ============================================================================================================================
+// migrateRepaymentV1 - type the string amounts
+// ============================================================================================================================
+func migrateRepaymentV1(doc map[string]interface{}) error {
+	for _, field := range []string{"amount", "balance_after"} {
+		if s, ok := doc[field].(string); ok {
+			doc[field] = legacyMoney(s)
+		}
+	}
+	return nil
+}
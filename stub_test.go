@@ -0,0 +1,284 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	mspproto "github.com/hyperledger/fabric-protos-go/msp"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// memStub - in-memory ledger for tests. Writes are buffered until commit and reads see the last committed
+// state, like on a peer. Rich queries fail as they do on LevelDB, so query_agreements scans the indexes.
+// Stub methods the chaincode never calls are left to the embedded nil interface and panic.
+type memStub struct {
+	shim.ChaincodeStubInterface
+	state   map[string][]byte
+	history map[string][]*queryresult.KeyModification
+	events  []pb.ChaincodeEvent // one per committed transaction that set an event
+
+	txCount int
+	txID    string
+	now     time.Time
+	creator []byte
+	writes  map[string][]byte // pending writes of the current transaction, nil deletes
+	event   *pb.ChaincodeEvent
+
+	queryErr error // returned by every rich query, LevelDB's refusal by default
+}
+
+func newMemStub() *memStub {
+	return &memStub{
+		state:   map[string][]byte{},
+		history: map[string][]*queryresult.KeyModification{},
+		now:     time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC),
+
+		queryErr: errors.New("ExecuteQuery not supported for leveldb"),
+	}
+}
+
+// begin - start a transaction submitted by creator, a minute after the previous one
+func (s *memStub) begin(creator []byte) {
+	s.txCount++
+	s.txID = fmt.Sprintf("tx%03d", s.txCount)
+	s.now = s.now.Add(time.Minute)
+	s.creator = creator
+	s.writes = map[string][]byte{}
+	s.event = nil
+}
+
+// commit - apply the pending writes in key order and record them in the key history
+func (s *memStub) commit() {
+	keys := make([]string, 0, len(s.writes))
+	for key := range s.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := s.writes[key]
+		if value == nil {
+			delete(s.state, key)
+		} else {
+			s.state[key] = value
+		}
+		s.history[key] = append(s.history[key], &queryresult.KeyModification{
+			TxId:      s.txID,
+			Value:     value,
+			Timestamp: timestamppb.New(s.now),
+			IsDelete:  value == nil,
+		})
+	}
+	if s.event != nil {
+		s.events = append(s.events, *s.event)
+	}
+	s.writes = nil
+}
+
+// lastEvent - the event of the last committed transaction that set one
+func (s *memStub) lastEvent() *pb.ChaincodeEvent {
+	if len(s.events) == 0 {
+		return nil
+	}
+	return &s.events[len(s.events)-1]
+}
+
+func (s *memStub) GetTxID() string             { return s.txID }
+func (s *memStub) GetCreator() ([]byte, error) { return s.creator, nil }
+func (s *memStub) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return timestamppb.New(s.now), nil
+}
+
+func (s *memStub) GetState(key string) ([]byte, error) {
+	return s.state[key], nil
+}
+
+func (s *memStub) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("empty key")
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	s.writes[key] = value
+	return nil
+}
+
+func (s *memStub) DelState(key string) error {
+	s.writes[key] = nil
+	return nil
+}
+
+func (s *memStub) SetEvent(name string, payload []byte) error {
+	s.event = &pb.ChaincodeEvent{TxId: s.txID, EventName: name, Payload: payload}
+	return nil
+}
+
+func (s *memStub) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	key := "\x00" + objectType + "\x00"
+	for _, attribute := range attributes {
+		if strings.ContainsRune(attribute, 0) {
+			return "", errors.New("composite key attributes may not contain U+0000")
+		}
+		key += attribute + "\x00"
+	}
+	return key, nil
+}
+
+func (s *memStub) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(compositeKey, "\x00"), "\x00"), "\x00")
+	return parts[0], parts[1:], nil
+}
+
+// sortedKeys - committed keys from start (inclusive) to end (exclusive) in key order
+func (s *memStub) sortedKeys(start, end string) []string {
+	var keys []string
+	for key := range s.state {
+		if key >= start && key < end {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s *memStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if endKey == "" {
+		endKey = "\U0010FFFF"
+	}
+	var keys []string
+	for _, key := range s.sortedKeys(startKey, endKey) {
+		if !strings.HasPrefix(key, "\x00") { //composite keys live in their own namespace
+			keys = append(keys, key)
+		}
+	}
+	return s.iterator(keys), nil
+}
+
+func (s *memStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := s.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return s.iterator(s.sortedKeys(prefix, prefix+"\U0010FFFF")), nil
+}
+
+func (s *memStub) GetStateByPartialCompositeKeyWithPagination(objectType string, attributes []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	prefix, err := s.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, nil, err
+	}
+	start := prefix
+	if bookmark != "" {
+		start = bookmark
+	}
+	keys := s.sortedKeys(start, prefix+"\U0010FFFF")
+	meta := &pb.QueryResponseMetadata{}
+	if len(keys) > int(pageSize) {
+		meta.Bookmark = keys[pageSize] //the next page starts at the first key left out
+		keys = keys[:pageSize]
+	}
+	meta.FetchedRecordsCount = int32(len(keys))
+	return s.iterator(keys), meta, nil
+}
+
+func (s *memStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return nil, nil, s.queryErr
+}
+
+func (s *memStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &memHistoryIterator{mods: s.history[key]}, nil
+}
+
+func (s *memStub) iterator(keys []string) *memStateIterator {
+	kvs := make([]*queryresult.KV, len(keys))
+	for i, key := range keys {
+		kvs[i] = &queryresult.KV{Key: key, Value: s.state[key]}
+	}
+	return &memStateIterator{kvs: kvs}
+}
+
+type memStateIterator struct {
+	kvs []*queryresult.KV
+}
+
+func (it *memStateIterator) HasNext() bool { return len(it.kvs) > 0 }
+func (it *memStateIterator) Close() error  { return nil }
+func (it *memStateIterator) Next() (*queryresult.KV, error) {
+	if len(it.kvs) == 0 {
+		return nil, errors.New("iterator exhausted")
+	}
+	kv := it.kvs[0]
+	it.kvs = it.kvs[1:]
+	return kv, nil
+}
+
+type memHistoryIterator struct {
+	mods []*queryresult.KeyModification
+}
+
+func (it *memHistoryIterator) HasNext() bool { return len(it.mods) > 0 }
+func (it *memHistoryIterator) Close() error  { return nil }
+func (it *memHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	if len(it.mods) == 0 {
+		return nil, errors.New("iterator exhausted")
+	}
+	mod := it.mods[0]
+	it.mods = it.mods[1:]
+	return mod, nil
+}
+
+// identityKey - one signing key shared by every test certificate
+var (
+	identityKey     *ecdsa.PrivateKey
+	identityKeyOnce sync.Once
+)
+
+// attrsOID - certificate extension where the Fabric CA stores attributes
+var attrsOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// newIdentity - a serialized creator with a self-signed certificate carrying the loan.role attribute
+func newIdentity(t *testing.T, mspID, name, roles string) []byte {
+	identityKeyOnce.Do(func() {
+		var err error
+		if identityKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+			t.Fatal(err)
+		}
+	})
+	attrs := map[string]string{RoleAttribute: roles}
+	attrsAsBytes, _ := json.Marshal(map[string]interface{}{"attrs": attrs})
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(time.Now().UnixNano()),
+		Subject:         pkix.Name{CommonName: name, Organization: []string{mspID}},
+		NotBefore:       time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:        time.Date(2035, 1, 1, 0, 0, 0, 0, time.UTC),
+		ExtraExtensions: []pkix.Extension{{Id: attrsOID, Value: attrsAsBytes}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &identityKey.PublicKey, identityKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	creator, err := proto.Marshal(&mspproto.SerializedIdentity{Mspid: mspID, IdBytes: certPEM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creator
+}